@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+
+	idmatch "github.com/src-d/identity-matching"
+)
+
+// explain prints the shortest chain of the merge edges between two aliases in the output of
+// a previous run or reports that the aliases belong to different people.
+func explain(arguments []string) {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s explain --output <path> [flags] <alias1> <alias2>\n\n"+
+			"An alias is an email or a name.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	output := flags.String("output", "", "path to the parquet file written by a previous run")
+	repo1 := flags.String("repo1", "", "repository of the first alias if it is a name")
+	repo2 := flags.String("repo2", "", "repository of the second alias if it is a name")
+	flags.SortFlags = false
+	_ = flags.Parse(arguments)
+	if *output == "" || flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	people, _, err := idmatch.ReadFromParquet(*output)
+	if err != nil {
+		logrus.Fatalf("failed to read the identities from %s: %v", *output, err)
+	}
+	alias1 := idmatch.Alias{Value: flags.Arg(0), Repo: *repo1}
+	alias2 := idmatch.Alias{Value: flags.Arg(1), Repo: *repo2}
+	explanation, err := idmatch.ExplainMatch(people, alias1, alias2)
+	if err != nil {
+		logrus.Fatalf("failed to explain: %v", err)
+	}
+	if !explanation.SamePerson() {
+		fmt.Printf("%s and %s are different people: %d and %d\n",
+			alias1, alias2, explanation.Person1, explanation.Person2)
+		return
+	}
+	if len(explanation.Chain) == 0 {
+		fmt.Printf("%s and %s are the same identity of person %d\n",
+			alias1, alias2, explanation.Person1)
+		return
+	}
+	fmt.Printf("%s and %s are person %d:\n", alias1, alias2, explanation.Person1)
+	for _, edge := range explanation.Chain {
+		fmt.Println(edge.String())
+	}
+}
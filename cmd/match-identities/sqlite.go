@@ -0,0 +1,6 @@
+// +build cgo
+
+package main
+
+// SQLite requires cgo, so its driver is not available in the static builds.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,143 @@
+package idmatch
+
+import (
+	"sort"
+
+	simplegraph "gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/iterator"
+	"gonum.org/v1/gonum/graph/simple"
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+// component is a connected component of the matching graph.
+type component struct {
+	members []node
+	// emails and names are the unique emails and names with repositories of the members.
+	emails map[string]struct{}
+	names  map[string]struct{}
+	// cannotLink are the sides of the cannot-link overrides which the members have.
+	cannotLink map[cannotLinkSide]struct{}
+}
+
+func newComponent(n node) *component {
+	c := &component{
+		members:    []node{n},
+		emails:     map[string]struct{}{},
+		names:      map[string]struct{}{},
+		cannotLink: map[cannotLinkSide]struct{}{},
+	}
+	for _, email := range n.Value.Emails {
+		c.emails[email] = struct{}{}
+	}
+	for _, name := range n.Value.NamesWithRepos {
+		c.names[name.String()] = struct{}{}
+	}
+	return c
+}
+
+// absorb adds the members of the other component.
+func (c *component) absorb(other *component) {
+	c.members = append(c.members, other.members...)
+	for email := range other.emails {
+		c.emails[email] = struct{}{}
+	}
+	for name := range other.names {
+		c.names[name] = struct{}{}
+	}
+	for side := range other.cannotLink {
+		c.cannotLink[side] = struct{}{}
+	}
+}
+
+// componentSet tracks the connected components of the matching graph incrementally, so that
+// the identities limit, the cannot-link overrides and the external identities are checked
+// without walking the graph.
+type componentSet struct {
+	set *disjointSet
+	// components are mapped to the representatives of their disjoint sets.
+	components map[int64]*component
+}
+
+// newComponentSet finds the connected components of the graph. cannotLink maps the people to
+// the sides of the cannot-link overrides they have.
+func newComponentSet(graph *simple.WeightedUndirectedGraph,
+	cannotLink map[int64][]cannotLinkSide) *componentSet {
+	s := &componentSet{set: newDisjointSet(), components: map[int64]*component{}}
+	nodes := graph.Nodes()
+	for nodes.Next() {
+		n := nodes.Node().(node)
+		c := newComponent(n)
+		for _, side := range cannotLink[n.ID()] {
+			c.cannotLink[side] = struct{}{}
+		}
+		s.components[n.ID()] = c
+	}
+	edges := graph.Edges()
+	for edges.Next() {
+		edge := edges.Edge()
+		s.join(edge.From().ID(), edge.To().ID())
+	}
+	return s
+}
+
+// get returns the component of the person.
+func (s *componentSet) get(id int64) *component {
+	return s.components[s.set.find(id)]
+}
+
+// join merges the components of the two people. The smaller component is absorbed by
+// the bigger one.
+func (s *componentSet) join(id1, id2 int64) {
+	root1, root2 := s.set.find(id1), s.set.find(id2)
+	if root1 == root2 {
+		return
+	}
+	c1, c2 := s.components[root1], s.components[root2]
+	delete(s.components, root1)
+	delete(s.components, root2)
+	if len(c1.members) < len(c2.members) {
+		c1, c2 = c2, c1
+	}
+	c1.absorb(c2)
+	s.set.union(root1, root2)
+	s.components[s.set.find(root1)] = c1
+}
+
+// sortNodes sorts the nodes by ID.
+func sortNodes(nodes []simplegraph.Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID() < nodes[j].ID() })
+}
+
+// connectedComponents returns the connected components of the graph with the nodes sorted
+// by ID. The components are sorted by their first nodes, so that the order does not depend
+// on the map iteration.
+func connectedComponents(graph simplegraph.Undirected) [][]simplegraph.Node {
+	components := topo.ConnectedComponents(graph)
+	for _, component := range components {
+		sortNodes(component)
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return components[i][0].ID() < components[j][0].ID()
+	})
+	return components
+}
+
+// orderedGraph iterates the nodes and their neighbors in the order of IDs, so that
+// the algorithms which walk the graph, e.g. the community detection, are deterministic.
+type orderedGraph struct {
+	*simple.WeightedUndirectedGraph
+}
+
+// Nodes returns all the nodes sorted by ID.
+func (g orderedGraph) Nodes() simplegraph.Nodes {
+	nodes := simplegraph.NodesOf(g.WeightedUndirectedGraph.Nodes())
+	sortNodes(nodes)
+	return iterator.NewOrderedNodes(nodes)
+}
+
+// From returns the neighbors of the node sorted by ID.
+func (g orderedGraph) From(id int64) simplegraph.Nodes {
+	nodes := simplegraph.NodesOf(g.WeightedUndirectedGraph.From(id))
+	sortNodes(nodes)
+	return iterator.NewOrderedNodes(nodes)
+}
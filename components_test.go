@@ -0,0 +1,44 @@
+package idmatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComponentSet(t *testing.T) {
+	req := require.New(t)
+	people := People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@google.com"},
+			ExternalID: "bob"},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@gmail.com"}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"robert", "repo"}},
+			Emails: []string{"bob@gmail.com"}},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice", ""}},
+			Emails: []string{"alice@google.com"}},
+	}
+	graph := newMatchingGraph(people, newTestBlacklist(t), 5)
+	req.Len(graph.components.components, 4)
+	req.NoError(graph.AddEdge(Edge{2, 3, "same email", "bob@gmail.com", 1}))
+	req.True(graph.components.get(2) == graph.components.get(3))
+	req.Len(graph.components.get(3).emails, 1)
+	req.Len(graph.components.get(3).names, 2)
+	req.True(graph.PassesIdentitiesLimit(1, 2))
+
+	req.NoError(graph.AddEdge(Edge{1, 2, "same name", "bob", 1}))
+	req.Len(graph.components.components, 2)
+	req.Len(graph.components.get(1).members, 3)
+	req.Equal("bob", people[3].ExternalID)
+	// 2 emails and 2 names of bob, 1 email and 1 name of alice
+	req.True(graph.PassesIdentitiesLimit(4, 1))
+	graph.maxIdentities = 4
+	req.False(graph.PassesIdentitiesLimit(4, 1))
+
+	graph.graph.RemoveEdge(1, 2)
+	graph.updateComponents()
+	req.Len(graph.components.components, 3)
+	req.True(graph.components.get(2) == graph.components.get(3))
+	req.Equal("bob", people[1].ExternalID)
+	req.Equal("", people[2].ExternalID)
+	req.Equal("", people[3].ExternalID)
+}
@@ -0,0 +1,103 @@
+package idmatch
+
+import (
+	"sort"
+
+	simplegraph "gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/simple"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+const (
+	// nameConfidence is the highest confidence of the edges by the same name.
+	nameConfidence = 0.9
+	// emailNameConfidence is the highest confidence of the edges by the email local part
+	// derived from the name.
+	emailNameConfidence = 0.7
+	// emailHalfConfidenceCount is the number of the identities with the same email which halves
+	// the confidence of the edges by that email.
+	emailHalfConfidenceCount = 50
+	// nameHalfConfidenceCount is the number of the identities with the same name which halves
+	// the confidence of the edges by that name.
+	nameHalfConfidenceCount = 10
+)
+
+// rarityConfidence returns the confidence of the edge by the shared value. It equals to
+// maxConfidence if the value is unique and decreases as the value becomes more frequent,
+// so that it is halved at halfCount other identities with the same value.
+func rarityConfidence(freqs map[string]*Frequency, value string, maxConfidence float64,
+	halfCount int) float64 {
+	freq, exists := freqs[value]
+	if !exists || freq.Total <= 1 {
+		return maxConfidence
+	}
+	return maxConfidence * float64(halfCount) / float64(halfCount+freq.Total-1)
+}
+
+// combineConfidence returns the confidence of two independent edges between the same people.
+func combineConfidence(confidence1, confidence2 float64) float64 {
+	return 1 - (1-confidence1)*(1-confidence2)
+}
+
+// removeWeakEdges removes the edges with the confidence lower than minConfidence so that they do
+// not join the components. The external identities are reassigned in the affected components.
+func (g *MatchingGraph) removeWeakEdges(minConfidence float64) {
+	if minConfidence <= 0 {
+		return
+	}
+	removed := map[[2]int64]struct{}{}
+	edges := g.graph.WeightedEdges()
+	for edges.Next() {
+		edge := edges.WeightedEdge()
+		if edge.Weight() < minConfidence {
+			removed[edgeKey(edge.From().ID(), edge.To().ID())] = struct{}{}
+		}
+	}
+	reporter.Commit("edges below the minimum confidence", len(removed))
+	if len(removed) == 0 {
+		return
+	}
+	for key := range removed {
+		g.graph.RemoveEdge(key[0], key[1])
+	}
+	g.removeProvenance(removed)
+	g.updateComponents()
+}
+
+// removeProvenance forgets the provenance of the removed edges.
+func (g *MatchingGraph) removeProvenance(removed map[[2]int64]struct{}) {
+	kept := g.edges[:0]
+	for _, edge := range g.edges {
+		if _, exists := removed[edgeKey(edge.Node1.ID, edge.Node2.ID)]; !exists {
+			kept = append(kept, edge)
+		}
+	}
+	g.edges = kept
+}
+
+// componentConfidence returns the confidence that all the people in the component are the same
+// person: the lowest edge weight in the maximum spanning tree of the component. In other words,
+// it is the highest confidence threshold which keeps the component connected.
+func componentConfidence(graph *simple.WeightedUndirectedGraph,
+	component []simplegraph.Node) float64 {
+	var edges []simplegraph.WeightedEdge
+	for _, n := range component {
+		neighbors := graph.From(n.ID())
+		for neighbors.Next() {
+			if other := neighbors.Node().ID(); n.ID() < other {
+				edges = append(edges, graph.WeightedEdge(n.ID(), other))
+			}
+		}
+	}
+	// the order of the edges with the same weight does not change the result
+	sort.Slice(edges, func(i, j int) bool { return edges[i].Weight() > edges[j].Weight() })
+	confidence := 1.0
+	forest := newDisjointSet()
+	for _, edge := range edges {
+		if forest.union(edge.From().ID(), edge.To().ID()) {
+			confidence = edge.Weight()
+		}
+	}
+	return confidence
+}
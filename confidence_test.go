@@ -0,0 +1,77 @@
+package idmatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gonum.org/v1/gonum/graph/simple"
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+func TestRarityConfidence(t *testing.T) {
+	req := require.New(t)
+	freqs := map[string]*Frequency{"john": {Total: 11}, "bob": {Total: 1}}
+	req.Equal(0.9, rarityConfidence(freqs, "bob", 0.9, 10))
+	req.Equal(0.9, rarityConfidence(freqs, "alice", 0.9, 10))
+	req.Equal(0.45, rarityConfidence(freqs, "john", 0.9, 10))
+	req.Equal(0.9, rarityConfidence(nil, "john", 0.9, 10))
+}
+
+func TestCombineConfidence(t *testing.T) {
+	req := require.New(t)
+	req.InDelta(0.75, combineConfidence(0.5, 0.5), 1e-9)
+	req.Equal(1.0, combineConfidence(1, 0.3))
+}
+
+func TestComponentConfidence(t *testing.T) {
+	req := require.New(t)
+	graph := simple.NewWeightedUndirectedGraph(0, 0)
+	for _, edge := range []struct {
+		from, to int64
+		weight   float64
+	}{{1, 2, 0.9}, {2, 3, 0.2}, {1, 3, 0.6}, {3, 4, 0.8}} {
+		graph.SetWeightedEdge(graph.NewWeightedEdge(
+			simple.Node(edge.from), simple.Node(edge.to), edge.weight))
+	}
+	graph.AddNode(simple.Node(5))
+	components := topo.ConnectedComponents(graph)
+	req.Len(components, 2)
+	for _, component := range components {
+		if len(component) == 1 {
+			req.Equal(1.0, componentConfidence(graph, component))
+		} else {
+			req.Equal(0.6, componentConfidence(graph, component))
+		}
+	}
+}
+
+func newSameNamePeople() People {
+	return People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"john smith", ""}},
+			Emails: []string{"john@google.com"}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"john smith", ""}},
+			Emails: []string{"jsmith@gmail.com"}},
+	}
+}
+
+func TestReducePeopleConfidence(t *testing.T) {
+	req := require.New(t)
+	blacklist := newTestBlacklist(t)
+	heuristics, err := NewHeuristics([]string{"name"}, HeuristicOptions{
+		NameFreqs: map[string]*Frequency{"john smith": {Total: 11}}})
+	req.NoError(err)
+
+	people := newSameNamePeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{Heuristics: heuristics}))
+	req.Len(people, 1)
+	req.Equal(0.45, people[1].Confidence)
+	req.Len(people[1].Provenance, 1)
+	req.Equal(0.45, people[1].Provenance[0].Confidence)
+
+	people = newSameNamePeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{
+		Heuristics: heuristics, Components: ComponentOptions{MinConfidence: 0.5}}))
+	req.Len(people, 2)
+	req.Equal(1.0, people[1].Confidence)
+	req.Empty(people[1].Provenance)
+}
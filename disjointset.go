@@ -0,0 +1,44 @@
+package idmatch
+
+// disjointSet is the union-find data structure over the people IDs.
+type disjointSet struct {
+	parents map[int64]int64
+	ranks   map[int64]int
+}
+
+func newDisjointSet() *disjointSet {
+	return &disjointSet{parents: map[int64]int64{}, ranks: map[int64]int{}}
+}
+
+// find returns the representative of the set with x.
+func (s *disjointSet) find(x int64) int64 {
+	parent, exists := s.parents[x]
+	if !exists {
+		return x
+	}
+	if parent == x {
+		return x
+	}
+	root := s.find(parent)
+	s.parents[x] = root
+	return root
+}
+
+// union joins the sets with x and y. It returns false if they are already the same set.
+func (s *disjointSet) union(x, y int64) bool {
+	x, y = s.find(x), s.find(y)
+	if x == y {
+		return false
+	}
+	if s.ranks[x] < s.ranks[y] {
+		x, y = y, x
+	}
+	s.parents[y] = x
+	if _, exists := s.parents[x]; !exists {
+		s.parents[x] = x
+	}
+	if s.ranks[x] == s.ranks[y] {
+		s.ranks[x]++
+	}
+	return true
+}
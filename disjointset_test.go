@@ -0,0 +1,21 @@
+package idmatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisjointSet(t *testing.T) {
+	req := require.New(t)
+	set := newDisjointSet()
+	req.Equal(int64(1), set.find(1))
+	req.True(set.union(1, 2))
+	req.True(set.union(3, 4))
+	req.False(set.union(2, 1))
+	req.NotEqual(set.find(1), set.find(3))
+	req.True(set.union(2, 4))
+	req.Equal(set.find(1), set.find(3))
+	req.False(set.union(1, 4))
+	req.Equal(int64(5), set.find(5))
+}
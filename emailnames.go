@@ -0,0 +1,131 @@
+package idmatch
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// genericLocalParts are the email local parts which do not identify a person.
+var genericLocalParts = map[string]struct{}{
+	"admin": {}, "administrator": {}, "contact": {}, "dev": {}, "developer": {},
+	"developers": {}, "git": {}, "github": {}, "hello": {}, "help": {}, "info": {}, "mail": {},
+	"me": {}, "no-reply": {}, "noreply": {}, "office": {}, "root": {}, "security": {},
+	"support": {}, "team": {}, "test": {}, "user": {}, "webmaster": {},
+}
+
+// minLocalPartLength is the minimum length of the email local part to derive a name from.
+// Shorter local parts such as "jli" are too ambiguous.
+const minLocalPartLength = 4
+
+// emailLocalPart returns the part of the email before "@" without the "+tag" suffix.
+func emailLocalPart(email string) string {
+	local := email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		local = email[:at]
+	}
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	return local
+}
+
+// nameLocalParts returns the email local parts which are commonly derived from the name,
+// e.g. "john.smith", "jsmith" and "johns" for "john smith". The first and the last words of
+// the name are used. The result is empty if the name has less than two words or some of them
+// are not made of letters only.
+func nameLocalParts(name string) []string {
+	tokens := nameTokens(name)
+	if len(tokens) < 2 {
+		return nil
+	}
+	first, last := tokens[0], tokens[len(tokens)-1]
+	for _, token := range []string{first, last} {
+		if len([]rune(token)) < 2 || strings.IndexFunc(token, func(r rune) bool {
+			return !unicode.IsLetter(r)
+		}) >= 0 {
+			return nil
+		}
+	}
+	f, l := string([]rune(first)[:1]), string([]rune(last)[:1])
+	return unique([]string{
+		first + "." + last, first + "_" + last, first + "-" + last, first + last,
+		last + "." + first, last + "_" + first, last + first,
+		f + last, f + "." + last, first + l, last + f,
+	})
+}
+
+// emailNameHeuristic connects the people whose email local parts are derived from the names
+// of other people, e.g. "john.smith@corp.com" or "jsmith@corp.com" and "John Smith".
+// This is one of Bird's heuristics. The popular emails, the generic local parts such as "info"
+// and the local parts which match several different names are skipped. The identities limit
+// applies.
+type emailNameHeuristic struct {
+	freqs map[string]*Frequency
+}
+
+func (emailNameHeuristic) Name() string {
+	return "email-name"
+}
+
+func (h emailNameHeuristic) AddEdges(people People, graph *MatchingGraph) error {
+	local2name := map[string]string{}
+	ambiguous := map[string]struct{}{}
+	name2ids := map[string][]int64{}
+	people.ForEach(func(id int64, person *Person) bool {
+		for _, name := range person.NamesWithRepos {
+			if name.Repo != "" || graph.Blacklist().isPopularName(name.Name) {
+				continue
+			}
+			if _, exists := name2ids[name.Name]; !exists {
+				for _, local := range nameLocalParts(name.Name) {
+					if other, exists := local2name[local]; exists && other != name.Name {
+						ambiguous[local] = struct{}{}
+					}
+					local2name[local] = name.Name
+				}
+			}
+			name2ids[name.Name] = append(name2ids[name.Name], id)
+		}
+		return false
+	})
+
+	matched := 0
+	var err error
+	people.ForEach(func(id int64, person *Person) bool {
+		for _, email := range person.Emails {
+			if graph.Blacklist().isPopularEmail(email) {
+				continue
+			}
+			local := emailLocalPart(email)
+			if _, generic := genericLocalParts[local]; generic || len(local) < minLocalPartLength {
+				continue
+			}
+			if _, exists := ambiguous[local]; exists {
+				reporter.Increment("ambiguous email local parts")
+				continue
+			}
+			name, exists := local2name[local]
+			if !exists {
+				continue
+			}
+			for _, otherID := range name2ids[name] {
+				if otherID == id || graph.HasEdge(id, otherID) ||
+					!graph.CompatibleExternalIDs(id, otherID) ||
+					!graph.PassesIdentitiesLimit(id, otherID) {
+					continue
+				}
+				err = graph.AddEdge(Edge{id, otherID, "email local part", email, rarityConfidence(
+					h.freqs, name, emailNameConfidence, nameHalfConfidenceCount)})
+				if err != nil {
+					return true
+				}
+				matched++
+			}
+		}
+		return false
+	})
+	reporter.Commit("email local part edges", matched)
+	return err
+}
@@ -0,0 +1,82 @@
+package idmatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailLocalPart(t *testing.T) {
+	req := require.New(t)
+	req.Equal("john.smith", emailLocalPart("john.smith@corp.com"))
+	req.Equal("jsmith", emailLocalPart("jsmith+git@corp.com"))
+	req.Equal("nodomain", emailLocalPart("nodomain"))
+}
+
+func TestNameLocalParts(t *testing.T) {
+	req := require.New(t)
+	req.Equal([]string{
+		"j.smith", "john-smith", "john.smith", "john_smith", "johns", "johnsmith", "jsmith",
+		"smith.john", "smith_john", "smithj", "smithjohn",
+	}, nameLocalParts("john smith"))
+	req.Contains(nameLocalParts("john a. smith"), "jsmith")
+	req.Len(nameLocalParts("john"), 0)
+	req.Len(nameLocalParts("j. smith"), 0)
+	req.Len(nameLocalParts("bob 2"), 0)
+}
+
+func TestAddEdgesWithEmailNames(t *testing.T) {
+	req := require.New(t)
+	people := People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"john smith", ""}},
+			Emails: []string{"john@gmail.com"}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"johnny", ""}},
+			Emails: []string{"jsmith+oss@corp.com"}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"js", ""}},
+			Emails: []string{"john.smith@corp.com"}},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"jane doe", ""}},
+			Emails: []string{"jane@gmail.com"}},
+		5: {ID: 5, NamesWithRepos: []NameWithRepo{{"john doe", ""}},
+			Emails: []string{"john@yahoo.com"}},
+		6: {ID: 6, NamesWithRepos: []NameWithRepo{{"someone", ""}},
+			Emails: []string{"jdoe@corp.com"}},
+		7: {ID: 7, NamesWithRepos: []NameWithRepo{{"info desk", ""}},
+			Emails: []string{"info@corp.com"}},
+		8: {ID: 8, NamesWithRepos: []NameWithRepo{{"desk", ""}},
+			Emails: []string{"info.desk@popular.com"}},
+	}
+	blacklist := newTestBlacklist(t)
+	blacklist.PopularEmails["info.desk@popular.com"] = struct{}{}
+	graph := newMatchingGraph(people, blacklist, 100)
+	req.NoError(emailNameHeuristic{}.AddEdges(people, graph))
+	req.True(graph.HasEdge(1, 2))
+	req.True(graph.HasEdge(1, 3))
+	// "jdoe" is ambiguous between "jane doe" and "john doe"
+	req.Equal(0, graph.graph.From(6).Len())
+	req.Equal(0, graph.graph.From(7).Len())
+	req.Equal(0, graph.graph.From(8).Len())
+	req.Equal(2, graph.graph.Edges().Len())
+}
+
+func TestReducePeopleEmailNames(t *testing.T) {
+	req := require.New(t)
+	newPeople := func() People {
+		return People{
+			1: {ID: 1, NamesWithRepos: []NameWithRepo{{"john smith", ""}},
+				Emails: []string{"john@gmail.com"}},
+			2: {ID: 2, NamesWithRepos: []NameWithRepo{{"johnny", ""}},
+				Emails: []string{"jsmith@corp.com"}},
+		}
+	}
+	blacklist := newTestBlacklist(t)
+	people := newPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{}))
+	req.Len(people, 2)
+	people = newPeople()
+	heuristics, err := NewHeuristics([]string{"email-name"}, HeuristicOptions{})
+	req.NoError(err)
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{Heuristics: heuristics}))
+	req.Equal(People{1: {ID: 1,
+		NamesWithRepos: []NameWithRepo{{"john smith", ""}, {"johnny", ""}},
+		Emails:         []string{"john@gmail.com", "jsmith@corp.com"}}}, withoutMatchDetails(people))
+}
@@ -0,0 +1,226 @@
+package idmatch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Alias is the email or the name of an identity to explain. The value is treated as an email
+// if it contains "@". Repo optionally scopes the name.
+type Alias struct {
+	Value string
+	Repo  string
+}
+
+func (a Alias) isEmail() bool {
+	return strings.Contains(a.Value, "@")
+}
+
+// String returns the alias value followed by the repository in parens if it is set.
+func (a Alias) String() string {
+	if a.Repo == "" {
+		return a.Value
+	}
+	return a.Value + " (" + a.Repo + ")"
+}
+
+// Explanation tells why two aliases belong to the same person or that they do not.
+type Explanation struct {
+	// Person1 and Person2 are the IDs of the people with the first and the second alias.
+	Person1 int64
+	Person2 int64
+	// Chain is the shortest sequence of edges which connects the identities with the aliases.
+	// The edges are oriented from the first alias to the second. The chain is empty if
+	// the people are different or if the aliases belong to the same identity.
+	Chain []EdgeProvenance
+}
+
+// SamePerson returns true if the aliases belong to the same person.
+func (e Explanation) SamePerson() bool {
+	return e.Person1 == e.Person2
+}
+
+// ExplainMatch finds the people with the aliases and reconstructs the shortest chain of merge
+// edges between the aliases from the provenance of the person.
+func ExplainMatch(people People, alias1, alias2 Alias) (*Explanation, error) {
+	var err error
+	if alias1, err = cleanAlias(alias1); err != nil {
+		return nil, err
+	}
+	if alias2, err = cleanAlias(alias2); err != nil {
+		return nil, err
+	}
+	id1, err := findAlias(people, alias1)
+	if err != nil {
+		return nil, err
+	}
+	id2, err := findAlias(people, alias2)
+	if err != nil {
+		return nil, err
+	}
+	explanation := &Explanation{Person1: id1, Person2: id2}
+	if id1 != id2 {
+		return explanation, nil
+	}
+	edges := people[id1].Provenance
+	sources := edgeNodesWithAlias(edges, alias1)
+	targets := edgeNodesWithAlias(edges, alias2)
+	for node := range sources {
+		if _, exists := targets[node]; exists {
+			return explanation, nil
+		}
+	}
+	if len(sources) == 0 || len(targets) == 0 {
+		// the person was not merged
+		return explanation, nil
+	}
+	explanation.Chain = shortestEdgeChain(edges, sources, targets)
+	if explanation.Chain == nil {
+		return nil, fmt.Errorf("the provenance of person %d does not connect %s and %s",
+			id1, alias1, alias2)
+	}
+	return explanation, nil
+}
+
+func cleanAlias(alias Alias) (Alias, error) {
+	var err error
+	if alias.isEmail() {
+		alias.Value, err = cleanEmail(alias.Value)
+	} else {
+		alias.Value, err = cleanName(alias.Value)
+	}
+	return alias, err
+}
+
+// findAlias returns the ID of the person with the alias. A name without the repository
+// matches the names which are not scoped to a repository if there are any, otherwise it matches
+// the names in all the repositories.
+func findAlias(people People, alias Alias) (int64, error) {
+	ids := map[int64]struct{}{}
+	if alias.isEmail() {
+		people.ForEach(func(id int64, person *Person) bool {
+			for _, email := range person.Emails {
+				if email == alias.Value {
+					ids[id] = struct{}{}
+				}
+			}
+			return false
+		})
+	} else {
+		var scopedIDs []int64
+		people.ForEach(func(id int64, person *Person) bool {
+			for _, name := range person.NamesWithRepos {
+				if name.Name != alias.Value {
+					continue
+				}
+				if name.Repo == "" || name.Repo == alias.Repo {
+					ids[id] = struct{}{}
+				} else if alias.Repo == "" {
+					scopedIDs = append(scopedIDs, id)
+				}
+			}
+			return false
+		})
+		if len(ids) == 0 {
+			for _, id := range scopedIDs {
+				ids[id] = struct{}{}
+			}
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("alias not found: %s", alias)
+	case 1:
+		for id := range ids {
+			return id, nil
+		}
+	}
+	var found []string
+	for id := range ids {
+		found = append(found, fmt.Sprint(id))
+	}
+	sort.Strings(found)
+	return 0, fmt.Errorf("alias %s belongs to several people: %s", alias,
+		strings.Join(found, ", "))
+}
+
+// edgeNodesWithAlias returns the IDs of the edge nodes which have the alias. The names are
+// matched the same way as findAlias does.
+func edgeNodesWithAlias(edges []EdgeProvenance, alias Alias) map[int64]struct{} {
+	nodes := map[int64]struct{}{}
+	scopedNodes := map[int64]struct{}{}
+	for _, edge := range edges {
+		for _, node := range []EdgeNode{edge.Node1, edge.Node2} {
+			if alias.isEmail() {
+				for _, email := range node.Emails {
+					if email == alias.Value {
+						nodes[node.ID] = struct{}{}
+					}
+				}
+				continue
+			}
+			for _, name := range node.Names {
+				if name.Name != alias.Value {
+					continue
+				}
+				if name.Repo == "" || name.Repo == alias.Repo {
+					nodes[node.ID] = struct{}{}
+				} else if alias.Repo == "" {
+					scopedNodes[node.ID] = struct{}{}
+				}
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		return scopedNodes
+	}
+	return nodes
+}
+
+// shortestEdgeChain runs the breadth-first search from the sources to the nearest target
+// and returns the oriented edges on the way. It returns nil if no target is reachable.
+func shortestEdgeChain(
+	edges []EdgeProvenance, sources, targets map[int64]struct{}) []EdgeProvenance {
+	adjacency := map[int64][]EdgeProvenance{}
+	for _, edge := range edges {
+		adjacency[edge.Node1.ID] = append(adjacency[edge.Node1.ID], edge)
+		adjacency[edge.Node2.ID] = append(adjacency[edge.Node2.ID], edge.reversed())
+	}
+	// the sources are sorted to make the chain deterministic
+	queue := make([]int64, 0, len(sources))
+	for node := range sources {
+		queue = append(queue, node)
+	}
+	sort.Slice(queue, func(i, j int) bool { return queue[i] < queue[j] })
+	parents := map[int64]EdgeProvenance{}
+	visited := map[int64]struct{}{}
+	for _, node := range queue {
+		visited[node] = struct{}{}
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if _, exists := targets[node]; exists {
+			var chain []EdgeProvenance
+			for {
+				edge, exists := parents[node]
+				if !exists {
+					break
+				}
+				chain = append([]EdgeProvenance{edge}, chain...)
+				node = edge.Node1.ID
+			}
+			return chain
+		}
+		for _, edge := range adjacency[node] {
+			if _, exists := visited[edge.Node2.ID]; exists {
+				continue
+			}
+			visited[edge.Node2.ID] = struct{}{}
+			parents[edge.Node2.ID] = edge
+			queue = append(queue, edge.Node2.ID)
+		}
+	}
+	return nil
+}
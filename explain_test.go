@@ -0,0 +1,87 @@
+package idmatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainMatch(t *testing.T) {
+	req := require.New(t)
+	people := People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@google.com"}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"bobby", ""}}, Emails: []string{"bobby@gmail.com"}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"robert", ""}},
+			Emails: []string{"bobby@gmail.com"}},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice", ""}}, Emails: []string{"alice@google.com"}},
+		5: {ID: 5, NamesWithRepos: []NameWithRepo{{"carol", "repo1"}},
+			Emails: []string{"carol@google.com"}},
+		6: {ID: 6, NamesWithRepos: []NameWithRepo{{"carol", "repo2"}},
+			Emails: []string{"carol@gmail.com"}},
+	}
+	mailmap := Mailmap{{ProperName: "Bob", ProperEmail: "bob@google.com",
+		CommitName: "Bobby", CommitEmail: "bobby@gmail.com"}}
+	req.NoError(ReducePeople(people, nil, newTestBlacklist(t), 100, ReduceOptions{Mailmap: mailmap}))
+
+	explanation, err := ExplainMatch(people, Alias{Value: "Bob@google.com"}, Alias{Value: "Robert"})
+	req.NoError(err)
+	req.True(explanation.SamePerson())
+	req.Len(explanation.Chain, 2)
+	req.Equal("mailmap", explanation.Chain[0].Heuristic)
+	req.Equal(int64(1), explanation.Chain[0].Node1.ID)
+	req.Equal(int64(2), explanation.Chain[0].Node2.ID)
+	req.Equal("email", explanation.Chain[1].Heuristic)
+	req.Equal("bobby@gmail.com", explanation.Chain[1].Value)
+	req.Equal(int64(2), explanation.Chain[1].Node1.ID)
+	req.Equal(int64(3), explanation.Chain[1].Node2.ID)
+
+	// the chain is oriented from the first alias to the second
+	explanation, err = ExplainMatch(people, Alias{Value: "robert"}, Alias{Value: "bob"})
+	req.NoError(err)
+	req.Len(explanation.Chain, 2)
+	req.Equal(int64(3), explanation.Chain[0].Node1.ID)
+	req.Equal(int64(1), explanation.Chain[1].Node2.ID)
+
+	explanation, err = ExplainMatch(people, Alias{Value: "bobby"}, Alias{Value: "bobby@gmail.com"})
+	req.NoError(err)
+	req.True(explanation.SamePerson())
+	req.Empty(explanation.Chain)
+
+	explanation, err = ExplainMatch(people, Alias{Value: "bob"}, Alias{Value: "alice"})
+	req.NoError(err)
+	req.False(explanation.SamePerson())
+	req.Equal(int64(1), explanation.Person1)
+	req.Equal(int64(4), explanation.Person2)
+	req.Empty(explanation.Chain)
+
+	explanation, err = ExplainMatch(people, Alias{Value: "carol", Repo: "repo2"},
+		Alias{Value: "alice"})
+	req.NoError(err)
+	req.Equal(int64(6), explanation.Person1)
+
+	// the names in the other repositories do not start the chain
+	people = People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"dan", "repo1"}}, Emails: []string{"dan@a.com"}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"dan", "repo2"}}, Emails: []string{"dan@b.com"}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"daniel", ""}},
+			Emails: []string{"dan@a.com", "dan@b.com"}},
+	}
+	req.NoError(ReducePeople(people, nil, newTestBlacklist(t), 100, ReduceOptions{}))
+	explanation, err = ExplainMatch(people, Alias{Value: "dan", Repo: "repo2"},
+		Alias{Value: "dan@a.com"})
+	req.NoError(err)
+	req.True(explanation.SamePerson())
+	req.NotEmpty(explanation.Chain)
+	req.Equal(int64(2), explanation.Chain[0].Node1.ID)
+
+	people = People{
+		5: {ID: 5, NamesWithRepos: []NameWithRepo{{"carol", "repo1"}},
+			Emails: []string{"carol@google.com"}},
+		6: {ID: 6, NamesWithRepos: []NameWithRepo{{"carol", "repo2"}},
+			Emails: []string{"carol@gmail.com"}},
+	}
+	_, err = ExplainMatch(people, Alias{Value: "carol"}, Alias{Value: "alice"})
+	req.EqualError(err, "alias carol belongs to several people: 5, 6")
+	_, err = ExplainMatch(people, Alias{Value: "nobody@google.com"}, Alias{Value: "alice"})
+	req.EqualError(err, "alias not found: nobody@google.com")
+}
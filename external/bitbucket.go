@@ -2,69 +2,115 @@ package external
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"net/http"
+	"net/mail"
+	"strings"
 
-	"github.com/wbrefvem/go-bitbucket"
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/identity-matching/reporter"
 )
 
 // BitBucketMatcher matches emails and BitBucket users.
 type BitBucketMatcher struct {
-	authContext context.Context
-	client      *bitbucket.APIClient
+	api restAPI
 }
 
-// NewBitBucketMatcher creates a new matcher given a BitBucket personal access token.
-// https://id.atlassian.com/manage/api-tokens
+// The BitBucket API rate limit per second.
+// https://confluence.atlassian.com/bitbucket/rate-limits-668173227.html
+const (
+	bitBucketRate  = 1000.0 / 3600
+	bitBucketBurst = 5
+)
+
+var bitBucketRepoRe = newRepoRe("bitbucket.org", `([^/]+)/([^/]+?)`)
+
+// NewBitBucketMatcher creates a new matcher given a BitBucket OAuth access token or
+// an app password in the form of "username:password".
+// https://confluence.atlassian.com/bitbucket/app-passwords-828781300.html
 func NewBitBucketMatcher(apiURL, token string) (Matcher, error) {
 	if apiURL == "" {
 		apiURL = "https://api.bitbucket.org/2.0"
 	}
-	ctx := context.WithValue(
-		context.Background(),
-		bitbucket.ContextAPIKey,
-		bitbucket.APIKey{Key: token},
-	)
-	client := bitbucket.NewAPIClient(bitbucket.NewConfiguration())
-	return BitBucketMatcher{authContext: ctx, client: client}, nil
+	return BitBucketMatcher{restAPI{
+		name:    "BitBucket",
+		url:     trimAPIURL(apiURL),
+		client:  &http.Client{},
+		limiter: NewRateLimiter(bitBucketRate, bitBucketBurst),
+		authorize: func(request *http.Request) {
+			if username, password := splitAppPassword(token); password != "" {
+				request.SetBasicAuth(username, password)
+			} else if token != "" {
+				request.Header.Set("Authorization", "Bearer "+token)
+			}
+		},
+		remainingKey: "X-RateLimit-Remaining",
+		resetKey:     "X-RateLimit-Reset",
+	}}, nil
 }
 
-// MatchByEmail returns the latest BitBucket user with the given email.
-func (m BitBucketMatcher) MatchByEmail(ctx context.Context, email string) (user string, err error) {
-	finished := make(chan struct{})
-	go func() {
-		defer func() { finished <- struct{}{} }()
-		var u bitbucket.User
-		var r *http.Response
-		u, r, err = m.client.UsersApi.UsersUsernameGet(m.authContext, email)
-		if err != nil {
-			// According to https://confluence.atlassian.com/bitbucket/rate-limits-668173227.html
-			// this API is not rate-limited.
-			if r.StatusCode == 404 {
-				err = ErrNoMatches
-			}
-			return
-		}
-		user = u.AccountId
-		// name = u.DisplayName
-	}()
-	select {
-	case <-finished:
-		return
-	case <-ctx.Done():
-		return "", context.Canceled
+// MatchByEmail always returns ErrNoMatches: BitBucket API does not allow to find users by email
+// since https://developer.atlassian.com/cloud/bitbucket/bitbucket-api-changes-gdpr/
+// The users are found by commit instead.
+func (m BitBucketMatcher) MatchByEmail(ctx context.Context, email string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
+	reporter.Increment("BitBucket emails without commits")
+	return "", ErrNoMatches
 }
 
 // SupportsMatchingByCommit indicates whether this Matcher allows querying identities by commit metadata.
 func (m BitBucketMatcher) SupportsMatchingByCommit() bool {
-	return false
+	return true
+}
+
+// bitBucketCommit is the commit returned by BitBucket API. The user is null if the author
+// does not have a BitBucket account.
+type bitBucketCommit struct {
+	Author struct {
+		Raw  string `json:"raw"`
+		User *struct {
+			AccountID string `json:"account_id"`
+		} `json:"user"`
+	} `json:"author"`
 }
 
-// MatchByCommit queries the identity of a given email address in a particular commit context.
+// MatchByCommit returns the account ID of the BitBucket user who authored the commit with
+// the given email. The repository must be hosted on bitbucket.org.
 func (m BitBucketMatcher) MatchByCommit(
-	ctx context.Context, email, repo, commit string) (user string, err error) {
-	return "", errors.New("not implemented")
+	ctx context.Context, email, repo, commit string) (string, error) {
+	parsedRepo := bitBucketRepoRe.FindStringSubmatch(repo)
+	if len(parsedRepo) < 4 || len(commit) != 40 {
+		return m.MatchByEmail(ctx, email)
+	}
+	var c bitBucketCommit
+	found, err := m.api.get(ctx, fmt.Sprintf("/repositories/%s/%s/commit/%s",
+		parsedRepo[2], parsedRepo[3], commit), &c)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		logrus.Warnf("unable to find the commit %s in %s", commit, repo)
+		return "", ErrNoMatches
+	}
+	author, err := mail.ParseAddress(c.Author.Raw)
+	if err != nil || c.Author.User == nil || c.Author.User.AccountID == "" ||
+		!strings.EqualFold(author.Address, email) {
+		logrus.Warnf("unable to find users by commit for email: %s", email)
+		return "", ErrNoMatches
+	}
+	return c.Author.User.AccountID, nil
+}
+
+// splitAppPassword returns the username and the password of the app password token, or empty
+// strings if it is not an app password.
+func splitAppPassword(token string) (string, string) {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
 }
 
 // OnIdle does nothing here.
@@ -0,0 +1,120 @@
+package external
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBitBucket serves the commit endpoint of BitBucket API with the given commits in JSON
+// mapped to the paths.
+type fakeBitBucket struct {
+	fakeServer
+	commits map[string]string
+}
+
+func (f *fakeBitBucket) serve(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/2.0/broken/") {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	commit, exists := f.commits[r.URL.Path]
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"type": "error", "error": {"message": "Resource not found"}}`))
+		return
+	}
+	_, _ = w.Write([]byte(commit))
+}
+
+func newFakeBitBucket(t *testing.T, token string) (*fakeBitBucket, Matcher, func()) {
+	prefix := "/2.0/repositories/team/project/commit/"
+	fake := &fakeBitBucket{commits: map[string]string{
+		prefix + fakeHash(1): `{"author": {"raw": "Victor Stinner <victor@python.org>",
+			"user": {"account_id": "557058:7bfcfebe"}}}`,
+		prefix + fakeHash(2): `{"author": {"raw": "Nobody <nobody@python.org>"}}`,
+	}}
+	fake.handle = fake.serve
+	matcher, cleanup := fake.start(t, func(url string) (Matcher, error) {
+		return NewBitBucketMatcher(url+"/2.0/", token)
+	})
+	return fake, matcher, cleanup
+}
+
+func TestBitBucketRepoRe(t *testing.T) {
+	req := require.New(t)
+	for _, repo := range []string{
+		"https://bitbucket.org/team/project.git",
+		"https://user@bitbucket.org/team/project",
+		"bitbucket.org/team/project/",
+		"git@bitbucket.org:team/project.git",
+	} {
+		parsed := bitBucketRepoRe.FindStringSubmatch(repo)
+		req.Len(parsed, 4, repo)
+		req.Equal([]string{"team", "project"}, parsed[2:], repo)
+	}
+	req.Nil(bitBucketRepoRe.FindStringSubmatch("https://github.com/team/project"))
+}
+
+func TestBitBucketMatcherByCommit(t *testing.T) {
+	req := require.New(t)
+	fake, matcher, cleanup := newFakeBitBucket(t, "token")
+	defer cleanup()
+	req.True(matcher.SupportsMatchingByCommit())
+	ctx := context.Background()
+	repo := "https://bitbucket.org/team/project.git"
+	user, err := matcher.MatchByCommit(ctx, "Victor@python.org", repo, fakeHash(1))
+	req.NoError(err)
+	req.Equal("557058:7bfcfebe", user)
+	for _, query := range []struct {
+		email  string
+		repo   string
+		commit string
+	}{
+		// the email is not in the commit
+		{"victor.stinner@gmail.com", repo, fakeHash(1)},
+		// the author does not have an account
+		{"nobody@python.org", repo, fakeHash(2)},
+		// the commit does not exist
+		{"victor@python.org", repo, fakeHash(3)},
+		// the repository is not on BitBucket
+		{"victor@python.org", "https://github.com/team/project", fakeHash(1)},
+	} {
+		user, err = matcher.MatchByCommit(ctx, query.email, query.repo, query.commit)
+		req.Equal(ErrNoMatches, err, query.email)
+		req.Equal("", user)
+	}
+	user, err = matcher.MatchByEmail(ctx, "victor@python.org")
+	req.Equal(ErrNoMatches, err)
+	req.Equal("", user)
+	req.Equal(4, fake.calls)
+	req.Equal("Bearer token", fake.auth[0])
+}
+
+func TestBitBucketMatcherErrors(t *testing.T) {
+	req := require.New(t)
+	fake, matcher, cleanup := newFakeBitBucket(t, "user:password")
+	defer cleanup()
+	fake.tooManyRequests = 1
+	repo := "bitbucket.org/team/project"
+	user, err := matcher.MatchByCommit(context.Background(), "victor@python.org", repo,
+		fakeHash(1))
+	req.NoError(err)
+	req.Equal("557058:7bfcfebe", user)
+	req.Equal(2, fake.calls)
+	req.True(strings.HasPrefix(fake.auth[0], "Basic "))
+
+	broken := matcher.(BitBucketMatcher)
+	broken.api.url += "/broken"
+	_, err = broken.MatchByCommit(context.Background(), "victor@python.org", repo,
+		fakeHash(1))
+	req.EqualError(err, "BitBucket API returned HTTP 500")
+	broken.api.url = "http://127.0.0.1:0"
+	_, err = broken.MatchByCommit(context.Background(), "victor@python.org", repo,
+		fakeHash(1))
+	req.Error(err)
+	req.NotEqual(ErrNoMatches, err)
+}
@@ -5,6 +5,7 @@ package external
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -23,9 +24,10 @@ func TestBitBucketMatcherMatchByEmail(t *testing.T) {
 	require.NoError(t, err)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	// BitBucket API no longer finds the users by email, see MatchByCommit
 	user, err := m.MatchByEmail(ctx, "victor.stinner@gmail.com")
-	require.NoError(t, err)
-	require.Equal(t, "557058:7bfcfebe-074d-4f48-9983-a8f959cf4a65", user)
+	require.Equal(t, ErrNoMatches, err)
+	require.Equal(t, "", user)
 }
 
 func TestBitBucketMatcherInvalidEmail(t *testing.T) {
@@ -47,3 +49,14 @@ func TestBitBucketMatcherCancel(t *testing.T) {
 	require.Equal(t, context.Canceled, err)
 	require.Equal(t, "", user)
 }
+
+func TestBitBucketMatcherCancelByCommit(t *testing.T) {
+	m, err := NewBitBucketMatcher("", bitbucketTestToken)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	user, err := m.MatchByCommit(ctx, "victor.stinner@gmail.com",
+		"https://bitbucket.org/haypo/misc", strings.Repeat("0", 40))
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, "", user)
+}
@@ -22,6 +22,7 @@ type CachedUser struct {
 type safeUserCache struct {
 	cache     map[string]CachedUser
 	lock      sync.RWMutex // mutex to make cache mapping safe for concurrent use
+	dumpLock  sync.Mutex   // mutex to avoid concurrent writes to the same file
 	cachePath string
 }
 
@@ -31,7 +32,8 @@ type CachedMatcher struct {
 	cache   safeUserCache
 }
 
-const saveFreq int = 20 // Dump cache to file each saveFreq usernames fetched
+const saveFreq int = 20         // Dump cache to file each saveFreq usernames fetched
+const cacheBatchSize int = 1000 // Dump cache to file after each batch of this many queries
 const csvTrue string = "1"
 const csvFalse string = "0"
 
@@ -53,8 +55,8 @@ func NewCachedMatcher(matcher Matcher, cachePath string) (*CachedMatcher, error)
 	logrus.WithFields(logrus.Fields{
 		"cachePath": cachePath,
 	}).Info("caching the external identities")
-	cache := safeUserCache{cache: make(map[string]CachedUser), cachePath: cachePath, lock: sync.RWMutex{}}
-	cachedMatcher := &CachedMatcher{matcher: matcher, cache: cache}
+	cachedMatcher := &CachedMatcher{matcher: matcher,
+		cache: safeUserCache{cache: make(map[string]CachedUser), cachePath: cachePath}}
 	var err error
 	if PathExists(cachePath) {
 		err = cachedMatcher.LoadCache()
@@ -73,12 +75,12 @@ func (m *CachedMatcher) LoadCache() error {
 
 // DumpCache saves the current CachedMatcher cache on disk.
 // It is a proxy for safeUserCache.DumpOnDisk() function.
-func (m CachedMatcher) DumpCache() error {
+func (m *CachedMatcher) DumpCache() error {
 	return m.cache.DumpOnDisk()
 }
 
 // OnIdle saves the current CachedMatcher cache on disk.
-func (m CachedMatcher) OnIdle() error {
+func (m *CachedMatcher) OnIdle() error {
 	return m.DumpCache()
 }
 
@@ -91,17 +93,10 @@ func (m *CachedMatcher) MatchByEmail(ctx context.Context, email string) (user st
 		return "", ErrNoMatches
 	}
 	user, err = m.matcher.MatchByEmail(ctx, email)
-	if err == nil {
-		m.cache.AddUserToCache(email, user, true)
-	}
-	if err == ErrNoMatches {
-		m.cache.AddUserToCache(email, user, false)
-	}
-	m.cache.lock.Lock()
-	if len(m.cache.cache)%saveFreq == 0 {
+	m.remember(email, user, err)
+	if m.cache.size()%saveFreq == 0 {
 		err = m.DumpCache()
 	}
-	m.cache.lock.Unlock()
 	return user, err
 }
 
@@ -120,18 +115,58 @@ func (m *CachedMatcher) MatchByCommit(
 		return "", ErrNoMatches
 	}
 	user, err = m.matcher.MatchByCommit(ctx, email, repo, commit)
+	m.remember(email, user, err)
+	if m.cache.size()%saveFreq == 0 {
+		err = m.DumpCache()
+	}
+	return user, err
+}
+
+// MatchBatch looks in the cache first and forwards the cache misses to the underlying Matcher,
+// in batches if it is a BatchMatcher. The cache is saved on disk after each batch.
+func (m *CachedMatcher) MatchBatch(ctx context.Context, queries []Query, workers int) []Result {
+	if _, ok := m.matcher.(BatchMatcher); !ok {
+		return matchConcurrently(ctx, m, queries, workers)
+	}
+	results := make([]Result, len(queries))
+	var misses []Query
+	var missIndexes []int
+	for i, query := range queries {
+		if username, exists := m.cache.ReadUserFromCache(query.Email); exists {
+			if username.Matched {
+				results[i].User = username.User
+			} else {
+				results[i].Err = ErrNoMatches
+			}
+			continue
+		}
+		misses = append(misses, query)
+		missIndexes = append(missIndexes, i)
+	}
+	for start := 0; start < len(misses); start += cacheBatchSize {
+		end := start + cacheBatchSize
+		if end > len(misses) {
+			end = len(misses)
+		}
+		for i, result := range matchAll(ctx, m.matcher, misses[start:end], workers) {
+			results[missIndexes[start+i]] = result
+			m.remember(misses[start+i].Email, result.User, result.Err)
+		}
+		if err := m.DumpCache(); err != nil {
+			logrus.Errorf("failed to write the external identities cache: %v", err)
+		}
+	}
+	return results
+}
+
+// remember adds the result of the query to the cache unless it is an unexpected error.
+func (m *CachedMatcher) remember(email, user string, err error) {
 	if err == nil {
 		m.cache.AddUserToCache(email, user, true)
 	}
 	if err == ErrNoMatches {
 		m.cache.AddUserToCache(email, user, false)
 	}
-	m.cache.lock.Lock()
-	if len(m.cache.cache)%saveFreq == 0 {
-		err = m.DumpCache()
-	}
-	m.cache.lock.Unlock()
-	return user, err
 }
 
 // Add to cache safely
@@ -141,8 +176,15 @@ func (m *safeUserCache) AddUserToCache(email string, user string, matched bool)
 	m.lock.Unlock()
 }
 
+// size returns the number of the cached emails.
+func (m *safeUserCache) size() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.cache)
+}
+
 // Read from cache safely
-func (m safeUserCache) ReadUserFromCache(email string) (CachedUser, bool) {
+func (m *safeUserCache) ReadUserFromCache(email string) (CachedUser, bool) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 	val, exists := m.cache[email]
@@ -200,10 +242,18 @@ func (m *safeUserCache) LoadFromDisk() error {
 }
 
 // DumpOnDisk saves cache on disk
-func (m safeUserCache) DumpOnDisk() error {
+func (m *safeUserCache) DumpOnDisk() error {
+	m.dumpLock.Lock()
+	defer m.dumpLock.Unlock()
+	m.lock.RLock()
+	cache := make(map[string]CachedUser, len(m.cache))
+	for email, user := range m.cache {
+		cache[email] = user
+	}
+	m.lock.RUnlock()
 	logrus.Infof("writing the external identities cache to %s", m.cachePath)
 	var file *os.File
-	existing := safeUserCache{cache: make(map[string]CachedUser), cachePath: m.cachePath, lock: sync.RWMutex{}}
+	existing := safeUserCache{cache: make(map[string]CachedUser), cachePath: m.cachePath}
 	flag := os.O_CREATE | os.O_WRONLY
 	if existing.LoadFromDisk() == nil && len(existing.cache) > 0 {
 		flag |= os.O_APPEND
@@ -233,14 +283,14 @@ func (m safeUserCache) DumpOnDisk() error {
 			return err
 		}
 	}
-	seq := make([]string, 0, len(m.cache))
-	for email := range m.cache {
+	seq := make([]string, 0, len(cache))
+	for email := range cache {
 		seq = append(seq, email)
 	}
 	sort.Strings(seq)
 	written := 0
 	for _, email := range seq {
-		username := m.cache[email]
+		username := cache[email]
 		if eusername, exists := existing.cache[email]; exists && eusername == username {
 			continue
 		}
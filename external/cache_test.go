@@ -33,9 +33,8 @@ func TestNewCachedMatcher(t *testing.T) {
 	_, err := cache.Write([]byte("email,user,match"))
 	req.NoError(err)
 	cachedMatcher, err := NewCachedMatcher(matcher, cache.Name())
-	scache := safeUserCache{
-		cache: make(map[string]CachedUser), cachePath: cache.Name(), lock: sync.RWMutex{}}
-	expectedCachedMatcher := &CachedMatcher{matcher: matcher, cache: scache}
+	expectedCachedMatcher := &CachedMatcher{matcher: matcher,
+		cache: safeUserCache{cache: make(map[string]CachedUser), cachePath: cache.Name()}}
 	req.NoError(err)
 	req.Equal(expectedCachedMatcher, cachedMatcher)
 }
@@ -0,0 +1,60 @@
+package external
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer is the common part of the fake provider APIs. It counts the requests, records
+// their authorization, rejects the unauthorized and the first tooManyRequests requests and
+// passes the rest to handle under the lock.
+type fakeServer struct {
+	lock  sync.Mutex
+	url   string
+	calls int
+	auth  []string
+	// authorization is the required Authorization header. Any is accepted if it is empty.
+	authorization string
+	// tooManyRequests is the number of the requests to reject before serving them.
+	tooManyRequests int
+	handle          func(w http.ResponseWriter, r *http.Request)
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls++
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	if f.authorization != "" && r.Header.Get("Authorization") != f.authorization {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if f.tooManyRequests > 0 {
+		f.tooManyRequests--
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	f.handle(w, r)
+}
+
+// start runs the fake server and creates the matcher with its URL. It returns the function
+// which stops the server.
+func (f *fakeServer) start(t *testing.T, newMatcher func(url string) (Matcher, error)) (
+	Matcher, func()) {
+	server := httptest.NewServer(f)
+	f.url = server.URL
+	matcher, err := newMatcher(server.URL)
+	require.NoError(t, err)
+	return matcher, server.Close
+}
+
+// fakeHash returns the commit hash for the fake servers.
+func fakeHash(i int) string {
+	return fmt.Sprintf("%040x", i)
+}
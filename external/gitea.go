@@ -0,0 +1,133 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GiteaMatcher matches emails and Gitea or Forgejo users.
+type GiteaMatcher struct {
+	api restAPI
+	// repoRe parses the URLs of the repositories hosted on this Gitea instance.
+	repoRe *regexp.Regexp
+}
+
+// giteaSearchLimit is the maximum number of the users returned by one search.
+const giteaSearchLimit = 50
+
+// NewGiteaMatcher creates a new matcher given a Gitea access token. The token must belong to
+// an administrator to find the users by their private emails. Gitea does not limit the rate
+// of the API calls by default, so the calls are only limited if the instance reports the quota.
+// https://docs.gitea.io/en-us/api-usage/
+func NewGiteaMatcher(apiURL, token string) (Matcher, error) {
+	if apiURL == "" {
+		apiURL = "https://gitea.com/api/v1"
+	}
+	parsedURL, err := url.Parse(apiURL)
+	if err != nil {
+		return GiteaMatcher{}, err
+	}
+	return GiteaMatcher{
+		api: restAPI{
+			name:    "Gitea",
+			url:     trimAPIURL(apiURL),
+			client:  &http.Client{},
+			limiter: NewRateLimiter(0, 1),
+			authorize: func(request *http.Request) {
+				if token != "" {
+					request.Header.Set("Authorization", "token "+token)
+				}
+			},
+			remainingKey: "X-RateLimit-Remaining",
+			resetKey:     "X-RateLimit-Reset",
+		},
+		repoRe: newRepoRe(parsedURL.Hostname(), `([^/]+)/([^/]+?)`),
+	}, nil
+}
+
+// giteaUser is the user returned by Gitea API. The email is hidden from non-administrators
+// unless the user made it public.
+type giteaUser struct {
+	Login string `json:"login"`
+	Email string `json:"email"`
+}
+
+// MatchByEmail returns the Gitea user with the given email.
+func (m GiteaMatcher) MatchByEmail(ctx context.Context, email string) (string, error) {
+	var result struct {
+		Data []giteaUser `json:"data"`
+	}
+	_, err := m.api.get(ctx, fmt.Sprintf("/users/search?q=%s&limit=%d",
+		url.QueryEscape(email), giteaSearchLimit), &result)
+	if err != nil {
+		return "", err
+	}
+	// the search also matches the logins and the names
+	for _, user := range result.Data {
+		if strings.EqualFold(user.Email, email) {
+			return user.Login, nil
+		}
+	}
+	logrus.Warnf("unable to find users for email: %s", email)
+	return "", ErrNoMatches
+}
+
+// SupportsMatchingByCommit indicates whether this Matcher allows querying identities by commit metadata.
+func (m GiteaMatcher) SupportsMatchingByCommit() bool {
+	return true
+}
+
+// giteaCommit is the commit returned by Gitea API. The author and the committer are the linked
+// accounts, they are null if nobody has the corresponding emails.
+type giteaCommit struct {
+	Commit struct {
+		Author struct {
+			Email string `json:"email"`
+		} `json:"author"`
+		Committer struct {
+			Email string `json:"email"`
+		} `json:"committer"`
+	} `json:"commit"`
+	Author    *giteaUser `json:"author"`
+	Committer *giteaUser `json:"committer"`
+}
+
+// MatchByCommit returns the Gitea user linked to the author or the committer with the given
+// email. The email is matched the same way as MatchByEmail does if the repository is not on
+// this Gitea instance or the commit does not exist.
+func (m GiteaMatcher) MatchByCommit(
+	ctx context.Context, email, repo, commit string) (string, error) {
+	parsedRepo := m.repoRe.FindStringSubmatch(repo)
+	if len(parsedRepo) < 4 || len(commit) != 40 {
+		return m.MatchByEmail(ctx, email)
+	}
+	var c giteaCommit
+	found, err := m.api.get(ctx, fmt.Sprintf("/repos/%s/%s/git/commits/%s",
+		url.PathEscape(parsedRepo[2]), url.PathEscape(parsedRepo[3]), commit), &c)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return m.MatchByEmail(ctx, email)
+	}
+	if c.Author != nil && c.Author.Login != "" && strings.EqualFold(c.Commit.Author.Email, email) {
+		return c.Author.Login, nil
+	}
+	if c.Committer != nil && c.Committer.Login != "" &&
+		strings.EqualFold(c.Commit.Committer.Email, email) {
+		return c.Committer.Login, nil
+	}
+	logrus.Warnf("unable to find users by commit for email: %s", email)
+	return "", ErrNoMatches
+}
+
+// OnIdle does nothing here.
+func (m GiteaMatcher) OnIdle() error {
+	return nil
+}
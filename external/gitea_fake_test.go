@@ -0,0 +1,135 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGitea serves the user search and the commits endpoints of Gitea API. The commits in JSON
+// are mapped to the paths.
+type fakeGitea struct {
+	fakeServer
+	users   []giteaUser
+	commits map[string]string
+}
+
+func (f *fakeGitea) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1/users/search" {
+		search := strings.ToLower(r.URL.Query().Get("q"))
+		users := []giteaUser{}
+		for _, user := range f.users {
+			if strings.Contains(strings.ToLower(user.Login), search) ||
+				strings.ToLower(user.Email) == search {
+				users = append(users, user)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "data": users})
+		return
+	}
+	if commit, exists := f.commits[r.URL.Path]; exists {
+		_, _ = w.Write([]byte(commit))
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`{"message": "object does not exist"}`))
+}
+
+func newFakeGitea(t *testing.T) (*fakeGitea, Matcher, func()) {
+	prefix := "/api/v1/repos/org/project/git/commits/"
+	fake := &fakeGitea{
+		fakeServer: fakeServer{authorization: "token admin"},
+		users: []giteaUser{
+			{Login: "alice", Email: "alice@corp.com"},
+			{Login: "bob", Email: "bob@corp.com"},
+			// the login contains the searched email
+			{Login: "carol@corp.com", Email: "carol.smith@corp.com"},
+		},
+		commits: map[string]string{
+			prefix + fakeHash(1): `{
+				"commit": {"author": {"email": "alice@corp.com"},
+				           "committer": {"email": "bob@corp.com"}},
+				"author": {"login": "alice", "email": "alice@corp.com"},
+				"committer": {"login": "bob", "email": "bob@corp.com"}}`,
+			prefix + fakeHash(2): `{
+				"commit": {"author": {"email": "dave@home.com"},
+				           "committer": {"email": "dave@home.com"}},
+				"author": null, "committer": null}`,
+		},
+	}
+	fake.handle = fake.serve
+	matcher, cleanup := fake.start(t, func(url string) (Matcher, error) {
+		return NewGiteaMatcher(url+"/api/v1/", "admin")
+	})
+	return fake, matcher, cleanup
+}
+
+func TestGiteaMatcherByEmail(t *testing.T) {
+	req := require.New(t)
+	_, matcher, cleanup := newFakeGitea(t)
+	defer cleanup()
+	ctx := context.Background()
+	user, err := matcher.MatchByEmail(ctx, "Bob@corp.com")
+	req.NoError(err)
+	req.Equal("bob", user)
+	for _, email := range []string{"carol@corp.com", "dave@home.com"} {
+		user, err = matcher.MatchByEmail(ctx, email)
+		req.Equal(ErrNoMatches, err, email)
+		req.Equal("", user)
+	}
+}
+
+func TestGiteaMatcherByCommit(t *testing.T) {
+	req := require.New(t)
+	fake, matcher, cleanup := newFakeGitea(t)
+	defer cleanup()
+	req.True(matcher.SupportsMatchingByCommit())
+	ctx := context.Background()
+	repo := fake.url + "/org/project.git"
+	for _, query := range []struct {
+		email  string
+		repo   string
+		commit string
+		user   string
+	}{
+		{"alice@corp.com", repo, fakeHash(1), "alice"},
+		{"bob@corp.com", repo, fakeHash(1), "bob"},
+		// the email is not in the commit
+		{"alice@corp.com", repo, fakeHash(2), ""},
+		// the accounts are not linked
+		{"dave@home.com", repo, fakeHash(2), ""},
+		// the commit does not exist so the email is searched
+		{"bob@corp.com", repo, fakeHash(3), "bob"},
+		// the repository is not on this instance so the email is searched
+		{"alice@corp.com", "https://github.com/org/project", fakeHash(1), "alice"},
+	} {
+		user, err := matcher.MatchByCommit(ctx, query.email, query.repo, query.commit)
+		req.Equal(query.user, user, query.email)
+		if query.user == "" {
+			req.Equal(ErrNoMatches, err, query.email)
+		} else {
+			req.NoError(err, query.email)
+		}
+	}
+	req.Equal(7, fake.calls)
+}
+
+func TestGiteaMatcherErrors(t *testing.T) {
+	req := require.New(t)
+	fake, _, cleanup := newFakeGitea(t)
+	defer cleanup()
+	matcher, err := NewGiteaMatcher(fake.url+"/api/v1", "user")
+	req.NoError(err)
+	_, err = matcher.MatchByEmail(context.Background(), "alice@corp.com")
+	req.EqualError(err, "Gitea API returned HTTP 401")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = matcher.MatchByCommit(ctx, "alice@corp.com", fake.url+"/org/project",
+		fakeHash(1))
+	req.Equal(context.Canceled, err)
+	req.Contains(Matchers, "gitea")
+}
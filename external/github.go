@@ -6,7 +6,6 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/src-d/identity-matching/reporter"
@@ -17,8 +16,22 @@ import (
 // GitHubMatcher matches emails and GitHub users.
 type GitHubMatcher struct {
 	client *github.Client
+	// search and core are shared by the concurrent queries to the Search API and to the rest
+	// of the API which have separate rate limits.
+	search *RateLimiter
+	core   *RateLimiter
 }
 
+// The GitHub API rate limits per second.
+// https://developer.github.com/v3/#rate-limiting
+const (
+	gitHubSearchRate          = 30.0 / 60
+	gitHubAnonymousSearchRate = 10.0 / 60
+	gitHubCoreRate            = 5000.0 / 3600
+	gitHubAnonymousCoreRate   = 60.0 / 3600
+	gitHubBurst               = 5
+)
+
 // NewGitHubMatcher creates a new matcher given a GitHub token.
 // https://github.com/settings/tokens
 func NewGitHubMatcher(apiURL, token string) (Matcher, error) {
@@ -26,7 +39,9 @@ func NewGitHubMatcher(apiURL, token string) (Matcher, error) {
 		apiURL = "https://api.github.com/"
 	}
 	var c *http.Client
+	searchRate, coreRate := gitHubAnonymousSearchRate, gitHubAnonymousCoreRate
 	if token != "" {
+		searchRate, coreRate = gitHubSearchRate, gitHubCoreRate
 		c = oauth2.NewClient(
 			context.Background(),
 			oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}),
@@ -37,7 +52,11 @@ func NewGitHubMatcher(apiURL, token string) (Matcher, error) {
 	if err != nil {
 		return GitHubMatcher{}, err
 	}
-	return GitHubMatcher{client}, nil
+	return GitHubMatcher{
+		client: client,
+		search: NewRateLimiter(searchRate, gitHubBurst),
+		core:   NewRateLimiter(coreRate, gitHubBurst),
+	}, nil
 }
 
 var searchOpts = &github.SearchOptions{
@@ -50,11 +69,16 @@ const (
 	responseSuccess = 0
 	responseRetry   = 1
 	responseFail    = 2
+	// responseBackoff means that the query must wait, see backoff(), and then retry.
+	responseBackoff = 3
 	maxNumFailures  = 8
 )
 
 // MatchByEmail returns the latest GitHub user with the given email.
 func (m GitHubMatcher) MatchByEmail(ctx context.Context, email string) (user string, err error) {
+	if isNoReplyEmail(email) {
+		return userFromEmail(email), nil
+	}
 	finished := make(chan struct{})
 	go func() {
 		defer func() { finished <- struct{}{} }()
@@ -62,36 +86,40 @@ func (m GitHubMatcher) MatchByEmail(ctx context.Context, email string) (user str
 		var numFailures uint64
 		query := email + " in:email"
 		for { // api rate limit retry loop
-			if isNoReplyEmail(email) {
-				user = userFromEmail(email)
-			} else {
-				var result *github.UsersSearchResult
-				var response *github.Response
-				result, response, err = m.client.Search.Users(ctx, query, searchOpts)
-				reporter.Increment("total GitHub API calls")
-				reporter.Increment("GitHub API search calls")
-				status := checkResponse(response, err, &numFailures)
-				if status == responseRetry {
-					reporter.Increment("GitHub API calls returning retry")
-					continue
-				} else if status == responseFail {
-					reporter.Increment("GitHub API calls failed")
-					return
-				}
-				reporter.Increment("GitHub API calls succeeded")
-				if len(result.Users) == 0 {
-					if strings.Contains(query, "@") {
-						// Hacking time! user+domain may work instead of user@domain
-						query = strings.Replace(query, "@", " ", 1)
-						continue
+			if err = m.search.Wait(ctx); err != nil {
+				return
+			}
+			var result *github.UsersSearchResult
+			var response *github.Response
+			result, response, err = m.client.Search.Users(ctx, query, searchOpts)
+			reporter.Increment("total GitHub API calls")
+			reporter.Increment("GitHub API search calls")
+			status := checkResponse(response, err, &numFailures, m.search)
+			if status == responseRetry || status == responseBackoff {
+				reporter.Increment("GitHub API calls returning retry")
+				if status == responseBackoff {
+					if err = backoff(ctx, numFailures); err != nil {
+						return
 					}
-					logrus.Warnf("unable to find users for email: %s", email)
-					err = ErrNoMatches
-					return
 				}
-				user = result.Users[0].GetLogin()
-				break
+				continue
+			} else if status == responseFail {
+				reporter.Increment("GitHub API calls failed")
+				return
+			}
+			reporter.Increment("GitHub API calls succeeded")
+			if len(result.Users) == 0 {
+				if strings.Contains(query, "@") {
+					// Hacking time! user+domain may work instead of user@domain
+					query = strings.Replace(query, "@", " ", 1)
+					continue
+				}
+				logrus.Warnf("unable to find users for email: %s", email)
+				err = ErrNoMatches
+				return
 			}
+			user = result.Users[0].GetLogin()
+			break
 		}
 	}()
 	select {
@@ -119,41 +147,48 @@ func (m GitHubMatcher) MatchByCommit(
 	}
 	repoUser := parsedRepo[2]
 	repoName := parsedRepo[3]
+	if isNoReplyEmail(email) {
+		return userFromEmail(email), nil
+	}
 	finished := make(chan struct{})
 	go func() {
 		defer func() { finished <- struct{}{} }()
 
 		var numFailures uint64
 		for { // api rate limit retry loop
-			if isNoReplyEmail(email) {
-				user = userFromEmail(email)
-			} else {
-				var c *github.RepositoryCommit
-				var response *github.Response
-				c, response, err = m.client.Repositories.GetCommit(ctx, repoUser, repoName, commit)
-				reporter.Increment("total GitHub API calls")
-				reporter.Increment("GitHub API query calls")
-				status := checkResponse(response, err, &numFailures)
-				if status == responseRetry {
-					reporter.Increment("GitHub API calls returning retry")
-					continue
-				} else if status == responseFail {
-					reporter.Increment("GitHub API calls failed")
-					return
-				}
-				reporter.Increment("GitHub API calls succeeded")
-				if c.Author != nil && c.Author.Login != nil && c.Commit.Author != nil &&
-					c.Commit.Author.Email != nil && *c.Commit.Author.Email == email {
-					user = *c.Author.Login
-				} else if c.Committer != nil && c.Committer.Login != nil && c.Commit.Committer != nil &&
-					c.Commit.Committer.Email != nil && *c.Commit.Committer.Email == email {
-					user = *c.Committer.Login
-				} else {
-					logrus.Warnf("unable to find users by commit for email: %s", email)
-					err = ErrNoMatches
+			if err = m.core.Wait(ctx); err != nil {
+				return
+			}
+			var c *github.RepositoryCommit
+			var response *github.Response
+			c, response, err = m.client.Repositories.GetCommit(ctx, repoUser, repoName, commit)
+			reporter.Increment("total GitHub API calls")
+			reporter.Increment("GitHub API query calls")
+			status := checkResponse(response, err, &numFailures, m.core)
+			if status == responseRetry || status == responseBackoff {
+				reporter.Increment("GitHub API calls returning retry")
+				if status == responseBackoff {
+					if err = backoff(ctx, numFailures); err != nil {
+						return
+					}
 				}
-				break
+				continue
+			} else if status == responseFail {
+				reporter.Increment("GitHub API calls failed")
+				return
 			}
+			reporter.Increment("GitHub API calls succeeded")
+			if c.Author != nil && c.Author.Login != nil && c.Commit.Author != nil &&
+				c.Commit.Author.Email != nil && *c.Commit.Author.Email == email {
+				user = *c.Author.Login
+			} else if c.Committer != nil && c.Committer.Login != nil && c.Commit.Committer != nil &&
+				c.Commit.Committer.Email != nil && *c.Commit.Committer.Email == email {
+				user = *c.Committer.Login
+			} else {
+				logrus.Warnf("unable to find users by commit for email: %s", email)
+				err = ErrNoMatches
+			}
+			break
 		}
 	}()
 	select {
@@ -169,40 +204,46 @@ func (m GitHubMatcher) OnIdle() error {
 	return nil
 }
 
-func checkResponse(response *github.Response, err error, numFailures *uint64) int {
-	code := response.Response.StatusCode
+// checkResponse updates the limiter with the quota reported by GitHub and decides whether
+// to retry the query. The queries are paused by the limiter if the rate limit was hit, while
+// the failed query backs off exponentially on its own.
+func checkResponse(response *github.Response, err error, numFailures *uint64,
+	limiter *RateLimiter) int {
+	var code int
+	var header http.Header
+	if response != nil && response.Response != nil {
+		code = response.StatusCode
+		header = response.Header
+	}
 	if err == nil && code >= 200 && code < 300 {
+		limiter.UpdateFromHeaders(header, "X-Ratelimit-Remaining", "X-Ratelimit-Reset")
 		return responseSuccess
 	}
 
-	rateLimitHit := false
-	if val, exists := response.Response.Header["X-Ratelimit-Remaining"]; code == 403 &&
-		exists && len(val) == 1 && val[0] == "0" {
-		rateLimitHit = true
-	}
-	if rateLimitHit {
-		t, err := strconv.ParseInt(
-			response.Response.Header["X-Ratelimit-Reset"][0], 10, 64)
-		if err != nil {
-			logrus.Errorf("Bad X-Ratelimit-Reset header: %v", err)
+	if (code == 403 || code == 429) && quotaExhausted(header, "X-Ratelimit-Remaining") {
+		if header.Get("Retry-After") == "" {
+			if _, err := strconv.ParseInt(header.Get("X-Ratelimit-Reset"), 10, 64); err != nil {
+				logrus.Errorf("Bad X-Ratelimit-Reset header: %v", err)
+				return responseFail
+			}
+		}
+		limiter.UpdateFromHeaders(header, "X-Ratelimit-Remaining", "X-Ratelimit-Reset")
+		*numFailures++
+		if *numFailures > maxNumFailures {
 			return responseFail
 		}
-		resetTime := time.Unix(t, 0).Add(time.Second)
-		logrus.Warnf("rate limit was hit, waiting until %s", resetTime.String())
-		time.Sleep(resetTime.Sub(time.Now().UTC()))
+		// the reset may be already passed
+		limiter.Pause(backoffDelay(*numFailures))
 		return responseRetry
 	}
 
 	if err != nil || code >= 500 && code < 600 || code == 408 || code == 429 {
-		sleepTime := time.Duration((1 << *numFailures) * int64(time.Second))
-		logrus.Warnf("HTTP %d: %s, sleeping until %s", code, err,
-			time.Now().UTC().Add(sleepTime))
-		time.Sleep(sleepTime)
+		logrus.Warnf("HTTP %d: %s", code, err)
 		*numFailures++
 		if *numFailures > maxNumFailures {
 			return responseFail
 		}
-		return responseRetry
+		return responseBackoff
 	}
 	logrus.Warnf("HTTP %d: %s", code, err)
 	return responseFail
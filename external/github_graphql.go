@@ -0,0 +1,289 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/identity-matching/reporter"
+	"golang.org/x/oauth2"
+	"gopkg.in/google/go-github.v15/github"
+)
+
+// GitHubGraphQLMatcher matches emails and GitHub users with GitHub GraphQL API v4.
+// The commits are resolved in batches: a single query looks up to gitHubGraphQLBatchSize commits
+// as aliased object(oid:) fields of their repositories. The emails without commits are searched
+// with the REST API the same way as GitHubMatcher does.
+type GitHubGraphQLMatcher struct {
+	rest    GitHubMatcher
+	url     string
+	client  *http.Client
+	limiter *RateLimiter
+}
+
+// gitHubGraphQLBatchSize is the maximum number of the commits in one GraphQL query.
+const gitHubGraphQLBatchSize = 100
+
+// NewGitHubGraphQLMatcher creates a new matcher given a GitHub token. GraphQL API does not allow
+// anonymous queries so the token is required.
+// https://github.com/settings/tokens
+func NewGitHubGraphQLMatcher(apiURL, token string) (Matcher, error) {
+	if token == "" {
+		return GitHubGraphQLMatcher{}, errors.New("GitHub GraphQL API requires a token")
+	}
+	rest, err := NewGitHubMatcher(apiURL, token)
+	if err != nil {
+		return GitHubGraphQLMatcher{}, err
+	}
+	if apiURL == "" {
+		apiURL = "https://api.github.com/"
+	}
+	// GitHub Enterprise serves REST API at /api/v3 and GraphQL API at /api/graphql
+	url := strings.TrimSuffix(strings.TrimSuffix(apiURL, "/"), "/v3") + "/graphql"
+	client := oauth2.NewClient(
+		context.Background(),
+		oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}),
+	)
+	return GitHubGraphQLMatcher{
+		rest:    rest.(GitHubMatcher),
+		url:     url,
+		client:  client,
+		limiter: NewRateLimiter(gitHubCoreRate, gitHubBurst),
+	}, nil
+}
+
+// MatchByEmail returns the latest GitHub user with the given email.
+func (m GitHubGraphQLMatcher) MatchByEmail(ctx context.Context, email string) (string, error) {
+	return m.rest.MatchByEmail(ctx, email)
+}
+
+// SupportsMatchingByCommit indicates whether this Matcher allows querying identities by commit metadata.
+func (m GitHubGraphQLMatcher) SupportsMatchingByCommit() bool {
+	return true
+}
+
+// MatchByCommit queries the identity of a given email address in a particular commit context.
+func (m GitHubGraphQLMatcher) MatchByCommit(
+	ctx context.Context, email, repo, commit string) (user string, err error) {
+	result := m.MatchBatch(ctx, []Query{{Email: email, Repo: repo, Commit: commit}}, 1)[0]
+	return result.User, result.Err
+}
+
+// OnIdle does nothing here.
+func (m GitHubGraphQLMatcher) OnIdle() error {
+	return nil
+}
+
+// gitHubCommitRef is the commit in the GitHub repository.
+type gitHubCommitRef struct {
+	owner  string
+	name   string
+	commit string
+}
+
+// MatchBatch resolves the queries with the GitHub commits in batches and the rest of the queries
+// one by one. Up to workers batches are queried at the same time.
+func (m GitHubGraphQLMatcher) MatchBatch(
+	ctx context.Context, queries []Query, workers int) []Result {
+	results := make([]Result, len(queries))
+	var emailQueries []Query
+	var emailIndexes []int
+	// the queries of the same commit are resolved together
+	commitIndexes := map[gitHubCommitRef][]int{}
+	var commits []gitHubCommitRef
+	for i, query := range queries {
+		parsedRepo := gitHubRepoRe.FindStringSubmatch(query.Repo)
+		if isNoReplyEmail(query.Email) || len(parsedRepo) < 4 || len(query.Commit) != 40 {
+			emailQueries = append(emailQueries, Query{Email: query.Email})
+			emailIndexes = append(emailIndexes, i)
+			continue
+		}
+		ref := gitHubCommitRef{parsedRepo[2], parsedRepo[3], query.Commit}
+		if _, exists := commitIndexes[ref]; !exists {
+			commits = append(commits, ref)
+		}
+		commitIndexes[ref] = append(commitIndexes[ref], i)
+	}
+	for i, result := range matchAll(ctx, m.rest, emailQueries, workers) {
+		results[emailIndexes[i]] = result
+	}
+	batches := make(chan []gitHubCommitRef)
+	var wg sync.WaitGroup
+	if workers < 1 {
+		workers = 1
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				found, err := m.queryCommits(ctx, batch)
+				for _, ref := range batch {
+					for _, i := range commitIndexes[ref] {
+						if err != nil {
+							results[i].Err = err
+						} else {
+							results[i].User, results[i].Err = found[ref].login(queries[i].Email)
+						}
+					}
+				}
+			}
+		}()
+	}
+	for start := 0; start < len(commits); start += gitHubGraphQLBatchSize {
+		end := start + gitHubGraphQLBatchSize
+		if end > len(commits) {
+			end = len(commits)
+		}
+		batches <- commits[start:end]
+	}
+	close(batches)
+	wg.Wait()
+	return results
+}
+
+// gitHubGraphQLActor is the author or the committer of the commit.
+type gitHubGraphQLActor struct {
+	Email string `json:"email"`
+	User  *struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// gitHubGraphQLCommit is the commit returned by the GraphQL query.
+type gitHubGraphQLCommit struct {
+	Author    gitHubGraphQLActor `json:"author"`
+	Committer gitHubGraphQLActor `json:"committer"`
+}
+
+// login returns the GitHub user of the author or the committer with the given email.
+// The emails are compared case-insensitively because the commits keep the original case.
+func (c *gitHubGraphQLCommit) login(email string) (string, error) {
+	if c != nil {
+		if c.Author.User != nil && strings.EqualFold(c.Author.Email, email) {
+			return c.Author.User.Login, nil
+		}
+		if c.Committer.User != nil && strings.EqualFold(c.Committer.Email, email) {
+			return c.Committer.User.Login, nil
+		}
+	}
+	logrus.Warnf("unable to find users by commit for email: %s", email)
+	return "", ErrNoMatches
+}
+
+// gitHubGraphQLResponse is the response to the GraphQL query of the commits: the repositories
+// and the commits in them are mapped to their aliases. The missing ones are null.
+type gitHubGraphQLResponse struct {
+	Data   map[string]map[string]*gitHubGraphQLCommit `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// buildCommitsQuery returns the GraphQL query of the commits and the aliases of the commits.
+func buildCommitsQuery(commits []gitHubCommitRef) (string, map[gitHubCommitRef][2]string) {
+	aliases := map[gitHubCommitRef][2]string{}
+	repos := map[[2]string]string{}
+	var repoOrder [][2]string
+	repoCommits := map[[2]string][]gitHubCommitRef{}
+	for _, ref := range commits {
+		repo := [2]string{ref.owner, ref.name}
+		if _, exists := repos[repo]; !exists {
+			repos[repo] = fmt.Sprintf("r%d", len(repos))
+			repoOrder = append(repoOrder, repo)
+		}
+		repoCommits[repo] = append(repoCommits[repo], ref)
+		aliases[ref] = [2]string{repos[repo], fmt.Sprintf("c%d", len(aliases))}
+	}
+	quote := func(s string) string {
+		quoted, _ := json.Marshal(s)
+		return string(quoted)
+	}
+	var query strings.Builder
+	query.WriteString("query {\n")
+	for _, repo := range repoOrder {
+		fmt.Fprintf(&query, "  %s: repository(owner: %s, name: %s) {\n",
+			repos[repo], quote(repo[0]), quote(repo[1]))
+		for _, ref := range repoCommits[repo] {
+			fmt.Fprintf(&query, "    %s: object(oid: %s) { ...actors }\n",
+				aliases[ref][1], quote(ref.commit))
+		}
+		query.WriteString("  }\n")
+	}
+	query.WriteString("}\n")
+	query.WriteString("fragment actors on Commit {\n" +
+		"  author { email user { login } }\n" +
+		"  committer { email user { login } }\n" +
+		"}\n")
+	return query.String(), aliases
+}
+
+// queryCommits runs the GraphQL query of the commits. The commits which were not found are nil.
+func (m GitHubGraphQLMatcher) queryCommits(
+	ctx context.Context, commits []gitHubCommitRef) (map[gitHubCommitRef]*gitHubGraphQLCommit, error) {
+	query, aliases := buildCommitsQuery(commits)
+	body, err := json.Marshal(map[string]string{"query": query})
+	if err != nil {
+		return nil, err
+	}
+	var numFailures uint64
+	for { // api rate limit retry loop
+		if err := m.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		request, err := http.NewRequest(http.MethodPost, m.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		response, err := m.client.Do(request.WithContext(ctx))
+		reporter.Increment("total GitHub API calls")
+		reporter.Increment("GitHub API GraphQL calls")
+		var parsed gitHubGraphQLResponse
+		if err == nil {
+			if response.StatusCode >= 200 && response.StatusCode < 300 {
+				err = json.NewDecoder(response.Body).Decode(&parsed)
+			}
+			_, _ = ioutil.ReadAll(response.Body)
+			_ = response.Body.Close()
+		}
+		status := checkResponse(&github.Response{Response: response}, err, &numFailures, m.limiter)
+		if status == responseRetry || status == responseBackoff {
+			reporter.Increment("GitHub API calls returning retry")
+			if status == responseBackoff {
+				if err := backoff(ctx, numFailures); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		} else if status == responseFail {
+			reporter.Increment("GitHub API calls failed")
+			if err == nil {
+				err = fmt.Errorf("GitHub GraphQL API returned HTTP %d", response.StatusCode)
+			}
+			return nil, err
+		}
+		// the missing repositories and commits are reported as errors but the rest is returned
+		if parsed.Data == nil {
+			reporter.Increment("GitHub API calls failed")
+			if len(parsed.Errors) > 0 {
+				return nil, fmt.Errorf("GitHub GraphQL API error: %s", parsed.Errors[0].Message)
+			}
+			return nil, errors.New("GitHub GraphQL API returned no data")
+		}
+		reporter.Increment("GitHub API calls succeeded")
+		found := make(map[gitHubCommitRef]*gitHubGraphQLCommit, len(commits))
+		for _, ref := range commits {
+			alias := aliases[ref]
+			found[ref] = parsed.Data[alias[0]][alias[1]]
+		}
+		return found, nil
+	}
+}
@@ -0,0 +1,288 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	fakeGraphQLRepoRe   = regexp.MustCompile(`(r\d+): repository\(owner: "([^"]+)", name: "([^"]+)"\)`)
+	fakeGraphQLCommitRe = regexp.MustCompile(`(c\d+): object\(oid: "([0-9a-f]+)"\)`)
+)
+
+// fakeGitHub serves GitHub GraphQL API with the given commits mapped to "owner/name" and
+// the REST user search with the given emails mapped to users.
+type fakeGitHub struct {
+	fakeServer
+	commits map[string]map[string]gitHubGraphQLCommit
+	users   map[string]string
+	// graphQLCalls is the number of the GraphQL queries and commitsPerCall are their sizes.
+	graphQLCalls   int
+	commitsPerCall []int
+	// rateLimited is the number of the GraphQL queries to reject before serving them.
+	rateLimited int
+	// broken makes GraphQL API return no data.
+	broken bool
+	// failing makes GraphQL API return HTTP 500.
+	failing bool
+}
+
+func (f *fakeGitHub) serve(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/search/users") {
+		email := strings.TrimSuffix(r.URL.Query().Get("q"), " in:email")
+		var items []map[string]string
+		if user, exists := f.users[email]; exists {
+			items = append(items, map[string]string{"login": user})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"total_count": len(items), "items": items})
+		return
+	}
+	if r.URL.Path != "/graphql" || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.graphQLCalls++
+	if f.rateLimited > 0 {
+		f.rateLimited--
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Unix()-10, 10))
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+		return
+	}
+	if f.failing {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if f.broken {
+		_, _ = w.Write([]byte(`{"data": null, "errors": [{"message": "Something went wrong"}]}`))
+		return
+	}
+	var body struct {
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	data := map[string]map[string]*gitHubGraphQLCommit{}
+	var repo map[string]gitHubGraphQLCommit
+	var repoAlias string
+	count := 0
+	for _, line := range strings.Split(body.Query, "\n") {
+		if match := fakeGraphQLRepoRe.FindStringSubmatch(line); match != nil {
+			repoAlias = match[1]
+			repo = f.commits[match[2]+"/"+match[3]]
+			data[repoAlias] = nil
+			if repo != nil {
+				data[repoAlias] = map[string]*gitHubGraphQLCommit{}
+			}
+		} else if match := fakeGraphQLCommitRe.FindStringSubmatch(line); match != nil {
+			count++
+			if repo == nil {
+				continue
+			}
+			if commit, exists := repo[match[2]]; exists {
+				data[repoAlias][match[1]] = &commit
+			} else {
+				data[repoAlias][match[1]] = nil
+			}
+		}
+	}
+	f.commitsPerCall = append(f.commitsPerCall, count)
+	w.Header().Set("X-RateLimit-Remaining", "4999")
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Unix()+3600, 10))
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func newFakeGitHubCommit(
+	author, authorEmail, committer, committerEmail string) gitHubGraphQLCommit {
+	var commit gitHubGraphQLCommit
+	commit.Author.Email = authorEmail
+	if author != "" {
+		commit.Author.User = &struct {
+			Login string `json:"login"`
+		}{author}
+	}
+	commit.Committer.Email = committerEmail
+	if committer != "" {
+		commit.Committer.User = &struct {
+			Login string `json:"login"`
+		}{committer}
+	}
+	return commit
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, Matcher, func()) {
+	fake := &fakeGitHub{
+		fakeServer: fakeServer{authorization: "Bearer token"},
+		commits: map[string]map[string]gitHubGraphQLCommit{
+			"src-d/hercules": {
+				fakeHash(1): newFakeGitHubCommit(
+					"vmarkovtsev", "vadim@sourced.tech", "web-flow", "noreply@github.com"),
+				fakeHash(2): newFakeGitHubCommit(
+					"", "unknown@sourced.tech", "mcuadros", "mcuadros@gmail.com"),
+			},
+			"src-d/gitbase": {
+				fakeHash(3): newFakeGitHubCommit(
+					"erizocosmico", "miguel@sourced.tech", "", ""),
+				fakeHash(5): newFakeGitHubCommit("smola", "Santiago@SourceD.tech", "", ""),
+			},
+		},
+		users: map[string]string{"eiso@sourced.tech": "eiso"},
+	}
+	fake.handle = fake.serve
+	matcher, cleanup := fake.start(t, func(url string) (Matcher, error) {
+		return NewGitHubGraphQLMatcher(url+"/", "token")
+	})
+	return fake, matcher, cleanup
+}
+
+func TestNewGitHubGraphQLMatcher(t *testing.T) {
+	req := require.New(t)
+	_, err := NewGitHubGraphQLMatcher("", "")
+	req.Error(err)
+	matcher, err := NewGitHubGraphQLMatcher("", "token")
+	req.NoError(err)
+	req.Equal("https://api.github.com/graphql", matcher.(GitHubGraphQLMatcher).url)
+	matcher, err = NewGitHubGraphQLMatcher("https://github.corp.com/api/v3/", "token")
+	req.NoError(err)
+	req.Equal("https://github.corp.com/api/graphql", matcher.(GitHubGraphQLMatcher).url)
+	req.True(matcher.SupportsMatchingByCommit())
+	req.Equal("github", Provider("github-graphql"))
+	req.Equal("gitlab", Provider("gitlab"))
+}
+
+func TestGitHubGraphQLMatcherBatch(t *testing.T) {
+	req := require.New(t)
+	fake, matcher, cleanup := newFakeGitHub(t)
+	defer cleanup()
+	hercules := "https://github.com/src-d/hercules.git"
+	queries := []Query{
+		{Email: "vadim@sourced.tech", Repo: hercules, Commit: fakeHash(1)},
+		{Email: "mcuadros@gmail.com", Repo: hercules, Commit: fakeHash(2)},
+		// the same commit with the author who does not have a GitHub account
+		{Email: "unknown@sourced.tech", Repo: hercules, Commit: fakeHash(2)},
+		{Email: "miguel@sourced.tech", Repo: "github.com/src-d/gitbase", Commit: fakeHash(3)},
+		// the commit email is mixed-case while the queried emails are clean
+		{Email: "santiago@sourced.tech", Repo: "github.com/src-d/gitbase",
+			Commit: fakeHash(5)},
+		{Email: "vadim@sourced.tech", Repo: hercules, Commit: fakeHash(4)},
+		{Email: "vadim@sourced.tech", Repo: "github.com/src-d/missing", Commit: fakeHash(1)},
+		// searched by email
+		{Email: "eiso@sourced.tech"},
+		{Email: "eiso@sourced.tech", Repo: "https://gitlab.com/src-d/hercules", Commit: "xxx"},
+		{Email: "123+bob@users.noreply.github.com", Repo: hercules, Commit: fakeHash(1)},
+	}
+	results := MatchAll(context.Background(), WithWorkers(matcher, 4), queries)
+	req.Equal([]Result{
+		{User: "vmarkovtsev"},
+		{User: "mcuadros"},
+		{Err: ErrNoMatches},
+		{User: "erizocosmico"},
+		{User: "smola"},
+		{Err: ErrNoMatches},
+		{Err: ErrNoMatches},
+		{User: "eiso"},
+		{User: "eiso"},
+		{User: "bob"},
+	}, results)
+	req.Equal(1, fake.graphQLCalls)
+	req.Equal([]int{6}, fake.commitsPerCall)
+
+	user, err := matcher.MatchByCommit(context.Background(), "mcuadros@gmail.com", hercules,
+		fakeHash(2))
+	req.NoError(err)
+	req.Equal("mcuadros", user)
+	req.Equal(2, fake.graphQLCalls)
+}
+
+func TestGitHubGraphQLMatcherBatchSize(t *testing.T) {
+	req := require.New(t)
+	fake, matcher, cleanup := newFakeGitHub(t)
+	defer cleanup()
+	queries := make([]Query, 250)
+	for i := range queries {
+		queries[i] = Query{Email: "vadim@sourced.tech", Repo: "github.com/src-d/hercules",
+			Commit: fakeHash(i + 1)}
+	}
+	results := MatchAll(context.Background(), WithWorkers(matcher, 2), queries)
+	req.Equal(Result{User: "vmarkovtsev"}, results[0])
+	for _, result := range results[1:] {
+		req.Equal(ErrNoMatches, result.Err)
+	}
+	req.Equal(3, fake.graphQLCalls)
+	req.ElementsMatch([]int{100, 100, 50}, fake.commitsPerCall)
+}
+
+func TestGitHubGraphQLMatcherErrors(t *testing.T) {
+	req := require.New(t)
+	fake, matcher, cleanup := newFakeGitHub(t)
+	defer cleanup()
+	hercules := "github.com/src-d/hercules"
+	fake.rateLimited = 1
+	user, err := matcher.MatchByCommit(context.Background(), "vadim@sourced.tech", hercules,
+		fakeHash(1))
+	req.NoError(err)
+	req.Equal("vmarkovtsev", user)
+	req.Equal(2, fake.graphQLCalls)
+
+	fake.broken = true
+	_, err = matcher.MatchByCommit(context.Background(), "vadim@sourced.tech", hercules,
+		fakeHash(1))
+	req.EqualError(err, "GitHub GraphQL API error: Something went wrong")
+
+	// the backoff after the server errors stops together with the context
+	fake.broken = false
+	fake.failing = true
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = matcher.MatchByCommit(ctx, "vadim@sourced.tech", hercules, fakeHash(1))
+	req.Equal(context.DeadlineExceeded, err)
+	req.True(time.Since(start) < time.Second)
+	fake.failing = false
+
+	unauthorized, err := NewGitHubGraphQLMatcher(fake.url+"/", "wrong")
+	req.NoError(err)
+	_, err = unauthorized.MatchByCommit(context.Background(), "vadim@sourced.tech", hercules,
+		fakeHash(1))
+	req.Error(err)
+}
+
+func TestCachedMatcherBatch(t *testing.T) {
+	req := require.New(t)
+	fake, matcher, cleanup := newFakeGitHub(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "identity-matching-")
+	req.NoError(err)
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, "cache.csv")
+	cached, err := NewCachedMatcher(matcher, cachePath)
+	req.NoError(err)
+	queries := []Query{
+		{Email: "vadim@sourced.tech", Repo: "github.com/src-d/hercules", Commit: fakeHash(1)},
+		{Email: "unknown@sourced.tech", Repo: "github.com/src-d/hercules",
+			Commit: fakeHash(2)},
+	}
+	expected := []Result{{User: "vmarkovtsev"}, {Err: ErrNoMatches}}
+	req.Equal(expected, MatchAll(context.Background(), WithWorkers(cached, 2), queries))
+	req.Equal(1, fake.graphQLCalls)
+	// the cache is saved after the batch
+	cached, err = NewCachedMatcher(matcher, cachePath)
+	req.NoError(err)
+	req.Equal(expected, MatchAll(context.Background(), WithWorkers(cached, 2), queries))
+	req.Equal(1, fake.graphQLCalls)
+}
@@ -2,7 +2,10 @@ package external
 
 import (
 	"context"
-	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/xanzy/go-gitlab"
@@ -11,16 +14,35 @@ import (
 // GitLabMatcher matches emails and GitLab users.
 type GitLabMatcher struct {
 	client *gitlab.Client
+	// limiter is shared by the concurrent queries.
+	limiter *RateLimiter
+	// repoRe parses the URLs of the repositories hosted on this GitLab instance.
+	repoRe *regexp.Regexp
 }
 
+// The GitLab.com API rate limit per second.
+// https://docs.gitlab.com/ee/user/gitlab_com/index.html#gitlabcom-specific-rate-limits
+const (
+	gitLabRate  = 600.0 / 60
+	gitLabBurst = 10
+)
+
 // NewGitLabMatcher creates a new matcher given a GitLab OAuth token.
 // https://gitlab.com/profile/personal_access_tokens
 func NewGitLabMatcher(apiURL, token string) (Matcher, error) {
 	if apiURL == "" {
 		apiURL = "https://gitlab.com/api/v4"
 	}
-	m := GitLabMatcher{gitlab.NewClient(nil, token)}
-	err := m.client.SetBaseURL(apiURL)
+	parsedURL, err := url.Parse(apiURL)
+	if err != nil {
+		return GitLabMatcher{}, err
+	}
+	m := GitLabMatcher{
+		client:  gitlab.NewClient(nil, token),
+		limiter: NewRateLimiter(gitLabRate, gitLabBurst),
+		repoRe:  newRepoRe(parsedURL.Hostname(), `(.+?)`),
+	}
+	err = m.client.SetBaseURL(apiURL)
 	if err != nil {
 		return GitLabMatcher{}, err
 	}
@@ -32,24 +54,7 @@ func (m GitLabMatcher) MatchByEmail(ctx context.Context, email string) (user str
 	finished := make(chan struct{})
 	go func() {
 		defer func() { finished <- struct{}{} }()
-		opts := &gitlab.ListUsersOptions{Search: &email}
-		for {
-			var users []*gitlab.User
-			users, _, err = m.client.Users.ListUsers(opts)
-			if err != nil {
-				// TODO(vmarkovtsev): handle rate limit
-				// https://github.com/xanzy/go-gitlab/issues/630
-				return
-			}
-			if len(users) == 0 {
-				logrus.Warnf("unable to find users for email: %s", email)
-				err = ErrNoMatches
-				return
-			}
-			user = users[0].Username
-			// name = users[0].Name
-			return
-		}
+		user, err = m.matchEmail(ctx, email)
 	}()
 	select {
 	case <-finished:
@@ -59,18 +64,159 @@ func (m GitLabMatcher) MatchByEmail(ctx context.Context, email string) (user str
 	}
 }
 
+// matchEmail searches the users by email.
+func (m GitLabMatcher) matchEmail(ctx context.Context, email string) (string, error) {
+	users, err := m.listUsers(ctx, email)
+	if err != nil {
+		return "", err
+	}
+	if len(users) == 0 {
+		logrus.Warnf("unable to find users for email: %s", email)
+		return "", ErrNoMatches
+	}
+	// name = users[0].Name
+	return users[0].Username, nil
+}
+
+// listUsers searches the users by email, name or username.
+func (m GitLabMatcher) listUsers(ctx context.Context, search string) ([]*gitlab.User, error) {
+	opts := &gitlab.ListUsersOptions{Search: &search}
+	var users []*gitlab.User
+	_, err := m.call(ctx, func(options ...gitlab.OptionFunc) (response *gitlab.Response, err error) {
+		users, response, err = m.client.Users.ListUsers(opts, options...)
+		return response, err
+	})
+	return users, err
+}
+
+// call runs the API call with the rate limit and retries it if the limit was hit.
+func (m GitLabMatcher) call(ctx context.Context,
+	apiCall func(options ...gitlab.OptionFunc) (*gitlab.Response, error)) (*gitlab.Response, error) {
+	var numFailures uint64
+	for { // api rate limit retry loop
+		if err := m.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		response, err := apiCall(gitlab.WithContext(ctx))
+		switch checkGitLabResponse(response, err, &numFailures, m.limiter) {
+		case responseRetry:
+			continue
+		case responseBackoff:
+			if err := backoff(ctx, numFailures); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return response, err
+	}
+}
+
 // SupportsMatchingByCommit indicates whether this Matcher allows querying identities by commit metadata.
 func (m GitLabMatcher) SupportsMatchingByCommit() bool {
-	return false
+	return true
 }
 
 // MatchByCommit queries the identity of a given email address in a particular commit context.
+// The commit is fetched from the project to find the name of its author or committer with
+// the email, then the project members, including the inherited ones, are searched by that name.
+// The member whose name or username equals it is returned if it is the only one. Otherwise,
+// the email is matched the same way as MatchByEmail does, which also happens if the repository
+// is not on this GitLab instance or the commit does not exist.
 func (m GitLabMatcher) MatchByCommit(
 	ctx context.Context, email, repo, commit string) (user string, err error) {
-	return "", errors.New("not implemented")
+	parsedRepo := m.repoRe.FindStringSubmatch(repo)
+	if len(parsedRepo) < 3 || len(commit) != 40 {
+		return m.MatchByEmail(ctx, email)
+	}
+	project := parsedRepo[2]
+	finished := make(chan struct{})
+	go func() {
+		defer func() { finished <- struct{}{} }()
+		var c *gitlab.Commit
+		var response *gitlab.Response
+		response, err = m.call(ctx, func(options ...gitlab.OptionFunc) (
+			response *gitlab.Response, err error) {
+			c, response, err = m.client.Commits.GetCommit(project, commit, options...)
+			return response, err
+		})
+		if err != nil {
+			if response != nil && response.StatusCode == http.StatusNotFound {
+				user, err = m.matchEmail(ctx, email)
+			}
+			return
+		}
+		var name string
+		if strings.EqualFold(c.AuthorEmail, email) {
+			name = c.AuthorName
+		} else if strings.EqualFold(c.CommitterEmail, email) {
+			name = c.CommitterName
+		} else {
+			logrus.Warnf("unable to find users by commit for email: %s", email)
+			err = ErrNoMatches
+			return
+		}
+		user, err = m.matchMember(ctx, project, name)
+		if err == ErrNoMatches {
+			user, err = m.matchEmail(ctx, email)
+		}
+	}()
+	select {
+	case <-finished:
+		return
+	case <-ctx.Done():
+		return "", context.Canceled
+	}
+}
+
+// matchMember searches the project members by name. It returns ErrNoMatches unless exactly one
+// member has the name or the username equal to the given name.
+func (m GitLabMatcher) matchMember(ctx context.Context, project, name string) (string, error) {
+	opts := &gitlab.ListProjectMembersOptions{Query: &name}
+	var members []*gitlab.ProjectMember
+	response, err := m.call(ctx, func(options ...gitlab.OptionFunc) (
+		response *gitlab.Response, err error) {
+		members, response, err = m.client.ProjectMembers.ListAllProjectMembers(
+			project, opts, options...)
+		return response, err
+	})
+	if err != nil {
+		// the members may be hidden from the token
+		if response != nil && (response.StatusCode == http.StatusForbidden ||
+			response.StatusCode == http.StatusNotFound) {
+			return "", ErrNoMatches
+		}
+		return "", err
+	}
+	var user string
+	for _, member := range members {
+		if !strings.EqualFold(member.Name, name) && !strings.EqualFold(member.Username, name) {
+			continue
+		}
+		if user != "" && user != member.Username {
+			logrus.Warnf("several project members are named %s", name)
+			return "", ErrNoMatches
+		}
+		user = member.Username
+	}
+	if user == "" {
+		return "", ErrNoMatches
+	}
+	return user, nil
 }
 
 // OnIdle does nothing here.
 func (m GitLabMatcher) OnIdle() error {
 	return nil
 }
+
+// checkGitLabResponse updates the limiter with the quota reported by GitLab and decides whether
+// to retry the query, see checkTooManyRequests.
+func checkGitLabResponse(response *gitlab.Response, err error, numFailures *uint64,
+	limiter *RateLimiter) int {
+	var httpResponse *http.Response
+	if response != nil {
+		httpResponse = response.Response
+	}
+	return checkTooManyRequests(httpResponse, err, numFailures, limiter,
+		"RateLimit-Remaining", "RateLimit-Reset")
+}
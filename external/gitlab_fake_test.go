@@ -0,0 +1,170 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
+)
+
+// fakeGitLab serves the commits, the project members and the users endpoints of GitLab API.
+// The commits are mapped to the project paths and the hashes.
+type fakeGitLab struct {
+	fakeServer
+	commits map[string]map[string]gitlab.Commit
+	members map[string][]*gitlab.ProjectMember
+	users   []*gitlab.User
+}
+
+func (f *fakeGitLab) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	if path == "/api/v4/users" {
+		search := strings.ToLower(r.URL.Query().Get("search"))
+		users := []*gitlab.User{}
+		for _, user := range f.users {
+			if strings.ToLower(user.PublicEmail) == search ||
+				strings.Contains(strings.ToLower(user.Name), search) {
+				users = append(users, user)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(users)
+		return
+	}
+	if strings.HasSuffix(path, "/members/all") {
+		project, _ := url.PathUnescape(strings.TrimSuffix(
+			strings.TrimPrefix(path, "/api/v4/projects/"), "/members/all"))
+		query := strings.ToLower(r.URL.Query().Get("query"))
+		members := []*gitlab.ProjectMember{}
+		for _, member := range f.members[project] {
+			if strings.Contains(strings.ToLower(member.Name), query) ||
+				strings.Contains(strings.ToLower(member.Username), query) {
+				members = append(members, member)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(members)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/api/v4/projects/"), "/repository/commits/")
+	if len(parts) == 2 {
+		project, _ := url.PathUnescape(parts[0])
+		if commit, exists := f.commits[project][parts[1]]; exists {
+			_ = json.NewEncoder(w).Encode(commit)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`{"message": "404 Not Found"}`))
+}
+
+func newFakeGitLab(t *testing.T) (*fakeGitLab, Matcher, func()) {
+	fake := &fakeGitLab{
+		commits: map[string]map[string]gitlab.Commit{
+			"group/subgroup/project": {
+				fakeHash(1): {AuthorName: "Vadim Markovtsev", AuthorEmail: "vadim@sourced.tech",
+					CommitterName: "Máximo Cuadros", CommitterEmail: "mcuadros@gmail.com"},
+				fakeHash(2): {AuthorName: "John Smith", AuthorEmail: "john@corp.com",
+					CommitterName: "John Smith", CommitterEmail: "john@corp.com"},
+				fakeHash(3): {AuthorName: "eiso", AuthorEmail: "eiso@corp.com",
+					CommitterName: "Eiso Kant", CommitterEmail: "kant@corp.com"},
+			},
+		},
+		members: map[string][]*gitlab.ProjectMember{
+			"group/subgroup/project": {
+				{Username: "vmarkovtsev", Name: "Vadim Markovtsev"},
+				{Username: "jsmith", Name: "John Smith"},
+				{Username: "john.smith", Name: "John Smith"},
+				{Username: "eiso", Name: "Eiso Kant"},
+			},
+		},
+		users: []*gitlab.User{
+			{Username: "vmarkovtsev", Name: "Vadim Markovtsev"},
+			{Username: "mcuadros", Name: "Máximo Cuadros", PublicEmail: "mcuadros@gmail.com"},
+			{Username: "jsmith", Name: "John Smith"},
+			{Username: "john.smith", Name: "John Smith"},
+			{Username: "eiso", Name: "Eiso Kant", PublicEmail: "eiso@sourced.tech"},
+		},
+	}
+	fake.handle = fake.serve
+	matcher, cleanup := fake.start(t, func(url string) (Matcher, error) {
+		return NewGitLabMatcher(url+"/api/v4", "token")
+	})
+	return fake, matcher, cleanup
+}
+
+func TestGitLabRepoRe(t *testing.T) {
+	req := require.New(t)
+	re := newRepoRe("gitlab.com", `(.+?)`)
+	for _, repo := range []string{
+		"https://gitlab.com/group/project.git",
+		"gitlab.com/group/project",
+		"git@gitlab.com:group/project.git",
+		"ssh://git@gitlab.com:2222/group/project",
+	} {
+		req.Equal("group/project", re.FindStringSubmatch(repo)[2], repo)
+	}
+	req.Equal("group/subgroup/project",
+		re.FindStringSubmatch("https://gitlab.com/group/subgroup/project/")[2])
+	req.Nil(re.FindStringSubmatch("https://github.com/group/project"))
+	req.Nil(newRepoRe("gitlab.corp.com", `(.+?)`).FindStringSubmatch("gitlab.com/group/project"))
+}
+
+func TestGitLabMatcherByCommit(t *testing.T) {
+	req := require.New(t)
+	fake, matcher, cleanup := newFakeGitLab(t)
+	defer cleanup()
+	req.True(matcher.SupportsMatchingByCommit())
+	ctx := context.Background()
+	repo := fake.url + "/group/subgroup/project.git"
+	for _, query := range []struct {
+		email  string
+		commit string
+		user   string
+	}{
+		// the email is private and the author is found among the project members by name
+		{"vadim@sourced.tech", fakeHash(1), "vmarkovtsev"},
+		// the committer is not a member and is found by the public email
+		{"mcuadros@gmail.com", fakeHash(1), "mcuadros"},
+		// the email is not in the commit
+		{"eiso@sourced.tech", fakeHash(1), ""},
+		// two members have the name and nobody has the email
+		{"john@corp.com", fakeHash(2), ""},
+		// found by the username and by the name
+		{"eiso@corp.com", fakeHash(3), "eiso"},
+		{"kant@corp.com", fakeHash(3), "eiso"},
+		// the commit does not exist so the email is searched
+		{"eiso@sourced.tech", fakeHash(4), "eiso"},
+		{"vadim@sourced.tech", fakeHash(4), ""},
+	} {
+		user, err := matcher.MatchByCommit(ctx, query.email, repo, query.commit)
+		req.Equal(query.user, user, query.email)
+		if query.user == "" {
+			req.Equal(ErrNoMatches, err, query.email)
+		} else {
+			req.NoError(err, query.email)
+		}
+	}
+
+	// the repository is not on this GitLab instance so the email is searched
+	calls := fake.calls
+	user, err := matcher.MatchByCommit(ctx, "eiso@sourced.tech",
+		"https://gitlab.com/group/subgroup/project", fakeHash(1))
+	req.NoError(err)
+	req.Equal("eiso", user)
+	req.Equal(calls+1, fake.calls)
+}
+
+func TestGitLabMatcherTooManyRequests(t *testing.T) {
+	req := require.New(t)
+	fake, matcher, cleanup := newFakeGitLab(t)
+	defer cleanup()
+	fake.tooManyRequests = 1
+	user, err := matcher.MatchByEmail(context.Background(), "eiso@sourced.tech")
+	req.NoError(err)
+	req.Equal("eiso", user)
+	req.Equal(2, fake.calls)
+}
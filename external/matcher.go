@@ -25,7 +25,24 @@ var ErrNoMatches = errors.New("no matches found")
 
 // Matchers is the registered external matcher constructors mapped to shorthands.
 var Matchers = map[string]MatcherConstructor{
-	"github":    NewGitHubMatcher,
-	"gitlab":    NewGitLabMatcher,
-	"bitbucket": NewBitBucketMatcher,
+	"github":         NewGitHubMatcher,
+	"github-graphql": NewGitHubGraphQLMatcher,
+	"gitlab":         NewGitLabMatcher,
+	"bitbucket":      NewBitBucketMatcher,
+	"gitea":          NewGiteaMatcher,
+}
+
+// providers maps the matchers which find the users of the same service as another matcher
+// to the name of that matcher.
+var providers = map[string]string{
+	"github-graphql": "github",
+}
+
+// Provider returns the name of the service whose users the registered matcher finds, so that
+// the matchers of the same service share the cache and the external IDs.
+func Provider(matcher string) string {
+	if provider, exists := providers[matcher]; exists {
+		return provider
+	}
+	return matcher
 }
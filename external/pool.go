@@ -0,0 +1,117 @@
+package external
+
+import (
+	"context"
+	"sync"
+)
+
+// Query is the email to match, optionally in the context of the commit.
+type Query struct {
+	Email string
+	// Repo and Commit are used by the matchers which support matching by commit.
+	Repo   string
+	Commit string
+}
+
+// Result is the identity found by a Query.
+type Result struct {
+	User string
+	Err  error
+}
+
+// ConcurrentMatcher is the Matcher which allows several queries at the same time.
+type ConcurrentMatcher interface {
+	Matcher
+	// Workers returns the maximum number of the concurrent queries.
+	Workers() int
+}
+
+type concurrentMatcher struct {
+	Matcher
+	workers int
+}
+
+func (m concurrentMatcher) Workers() int {
+	return m.workers
+}
+
+// WithWorkers allows MatchAll to run the given number of queries to the matcher at the same
+// time. The matcher must be safe for concurrent use. The built-in matchers share the rate limits
+// between the concurrent queries.
+func WithWorkers(matcher Matcher, workers int) ConcurrentMatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	return concurrentMatcher{matcher, workers}
+}
+
+// BatchMatcher is the Matcher which resolves many queries at once more efficiently than one by one.
+type BatchMatcher interface {
+	Matcher
+	// MatchBatch returns the results in the same order as the queries. workers is the maximum
+	// number of the concurrent requests.
+	MatchBatch(ctx context.Context, queries []Query, workers int) []Result
+}
+
+// MatchAll runs the queries with the pool of ConcurrentMatcher.Workers() goroutines or one by one
+// if the matcher is not a ConcurrentMatcher. The results are in the same order as the queries.
+// The queries with the commit are matched by commit if the matcher supports it. The queries are
+// passed to BatchMatcher.MatchBatch if the matcher is a BatchMatcher.
+func MatchAll(ctx context.Context, matcher Matcher, queries []Query) []Result {
+	workers := 1
+	if concurrent, ok := matcher.(concurrentMatcher); ok {
+		matcher = concurrent.Matcher
+		workers = concurrent.workers
+	} else if concurrent, ok := matcher.(ConcurrentMatcher); ok {
+		workers = concurrent.Workers()
+	}
+	return matchAll(ctx, matcher, queries, workers)
+}
+
+// matchAll is MatchAll with the given number of workers.
+func matchAll(ctx context.Context, matcher Matcher, queries []Query, workers int) []Result {
+	if batch, ok := matcher.(BatchMatcher); ok {
+		return batch.MatchBatch(ctx, queries, workers)
+	}
+	return matchConcurrently(ctx, matcher, queries, workers)
+}
+
+// matchConcurrently runs the queries one by one with the pool of the given number of goroutines.
+func matchConcurrently(
+	ctx context.Context, matcher Matcher, queries []Query, workers int) []Result {
+	results := make([]Result, len(queries))
+	match := func(i int) {
+		query := queries[i]
+		var result Result
+		if matcher.SupportsMatchingByCommit() && query.Commit != "" {
+			result.User, result.Err = matcher.MatchByCommit(
+				ctx, query.Email, query.Repo, query.Commit)
+		} else {
+			result.User, result.Err = matcher.MatchByEmail(ctx, query.Email)
+		}
+		results[i] = result
+	}
+	if workers <= 1 {
+		for i := range queries {
+			match(i)
+		}
+		return results
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				match(i)
+			}
+		}()
+	}
+	for i := range queries {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
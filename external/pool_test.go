@@ -0,0 +1,104 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// poolTestMatcher returns the part of the email before "@" or an error for "error@...".
+// It records the maximum number of the concurrent queries.
+type poolTestMatcher struct {
+	lock     sync.Mutex
+	running  int
+	maxRun   int
+	byCommit bool
+	commits  []string
+}
+
+func (m *poolTestMatcher) match(email, commit string) (string, error) {
+	m.lock.Lock()
+	m.running++
+	if m.running > m.maxRun {
+		m.maxRun = m.running
+	}
+	if commit != "" {
+		m.commits = append(m.commits, commit)
+	}
+	m.lock.Unlock()
+	time.Sleep(time.Millisecond)
+	m.lock.Lock()
+	m.running--
+	m.lock.Unlock()
+	user := strings.Split(email, "@")[0]
+	if user == "error" {
+		return "", errors.New(email)
+	}
+	return user, nil
+}
+
+func (m *poolTestMatcher) MatchByEmail(ctx context.Context, email string) (string, error) {
+	return m.match(email, "")
+}
+
+func (m *poolTestMatcher) SupportsMatchingByCommit() bool {
+	return m.byCommit
+}
+
+func (m *poolTestMatcher) MatchByCommit(
+	ctx context.Context, email, repo, commit string) (string, error) {
+	return m.match(email, commit)
+}
+
+func (m *poolTestMatcher) OnIdle() error {
+	return nil
+}
+
+func newPoolTestQueries() []Query {
+	var queries []Query
+	for _, user := range []string{"a", "b", "error", "c", "d", "e", "f", "g", "h", "i"} {
+		queries = append(queries, Query{Email: user + "@x.com"})
+	}
+	queries[1].Repo = "github.com/src-d/hercules"
+	queries[1].Commit = "d78a9c8b0c077b5ecdb3cf1e1efab4635c97dd7b"
+	return queries
+}
+
+func checkPoolTestResults(t *testing.T, results []Result) {
+	req := require.New(t)
+	req.Len(results, 10)
+	for i, user := range []string{"a", "b", "", "c", "d", "e", "f", "g", "h", "i"} {
+		req.Equal(user, results[i].User)
+		if user == "" {
+			req.EqualError(results[i].Err, "error@x.com")
+		} else {
+			req.NoError(results[i].Err)
+		}
+	}
+}
+
+func TestMatchAllSequential(t *testing.T) {
+	matcher := &poolTestMatcher{}
+	results := MatchAll(context.Background(), matcher, newPoolTestQueries())
+	checkPoolTestResults(t, results)
+	require.Equal(t, 1, matcher.maxRun)
+	require.Empty(t, matcher.commits)
+}
+
+func TestMatchAllConcurrent(t *testing.T) {
+	req := require.New(t)
+	matcher := &poolTestMatcher{byCommit: true}
+	concurrent := WithWorkers(matcher, 3)
+	req.Equal(3, concurrent.Workers())
+	results := MatchAll(context.Background(), concurrent, newPoolTestQueries())
+	checkPoolTestResults(t, results)
+	req.True(matcher.maxRun <= 3)
+	req.Equal([]string{"d78a9c8b0c077b5ecdb3cf1e1efab4635c97dd7b"}, matcher.commits)
+	req.Empty(MatchAll(context.Background(), concurrent, nil))
+	req.Equal(1, WithWorkers(matcher, 0).Workers())
+}
@@ -0,0 +1,202 @@
+package external
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RateLimiter is the token bucket which is shared by the concurrent queries to the same API.
+// The bucket is refilled at the configured rate and the API can lower it with the quota
+// reported in the response headers.
+type RateLimiter struct {
+	lock sync.Mutex
+	// rate is the number of the queries per second, zero means unlimited.
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// quotaRate is the rate which spreads the remaining quota until quotaReset.
+	quotaRate  float64
+	quotaReset time.Time
+	// now is time.Now which the tests replace.
+	now func() time.Time
+}
+
+// NewRateLimiter creates the limiter which allows the given number of queries per second with
+// the bursts of the given size. Zero rate means no limit until the API reports its quota.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), now: time.Now}
+}
+
+// Wait blocks until the query is allowed or the context is canceled.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for {
+		delay := l.reserve()
+		if delay <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token and returns zero or returns how long to wait for the next token.
+func (l *RateLimiter) reserve() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	rate := l.rate
+	if now.Before(l.quotaReset) {
+		if l.quotaRate == 0 {
+			return l.quotaReset.Sub(now)
+		}
+		if rate == 0 || l.quotaRate < rate {
+			rate = l.quotaRate
+		}
+	}
+	if rate == 0 {
+		return 0
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / rate * float64(time.Second))
+}
+
+// Update sets the quota reported by the API: the number of the remaining queries until
+// the reset time. The queries are paused until the reset if there are none left, otherwise
+// they are spread evenly.
+func (l *RateLimiter) Update(remaining int, reset time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	if !reset.After(now) {
+		return
+	}
+	if remaining <= 0 {
+		l.quotaRate = 0
+		logrus.Warnf("rate limit was hit, waiting until %s", reset.String())
+	} else {
+		l.quotaRate = float64(remaining) / reset.Sub(now).Seconds()
+	}
+	l.quotaReset = reset
+}
+
+// UpdateFromHeaders sets the quota from the response headers with the number of
+// the remaining queries and the Unix time of the reset, e.g. "X-RateLimit-Remaining" and
+// "X-RateLimit-Reset". Retry-After is respected as well. The missing or invalid headers are
+// ignored.
+func (l *RateLimiter) UpdateFromHeaders(header http.Header, remainingKey, resetKey string) {
+	if header == nil {
+		return
+	}
+	if after, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		l.Update(0, l.now().Add(time.Duration(after)*time.Second))
+		return
+	}
+	remaining, err := strconv.Atoi(header.Get(remainingKey))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get(resetKey), 10, 64)
+	if err != nil {
+		return
+	}
+	l.Update(remaining, time.Unix(reset, 0).Add(time.Second))
+}
+
+// Pause stops the queries for the given duration unless they are already stopped for longer.
+func (l *RateLimiter) Pause(duration time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	until := l.now().Add(duration)
+	if l.quotaRate == 0 && !until.After(l.quotaReset) {
+		return
+	}
+	l.quotaRate = 0
+	l.quotaReset = until
+	logrus.Warnf("pausing the queries until %s", until.String())
+}
+
+// quotaExhausted returns whether the response headers tell that the rate limit was hit.
+func quotaExhausted(header http.Header, remainingKey string) bool {
+	return header.Get("Retry-After") != "" || header.Get(remainingKey) == "0"
+}
+
+// backoffBase is the backoff after the first failure. The tests shorten it.
+var backoffBase = time.Second
+
+// backoffDelay returns the exponential backoff after the given number of the failures.
+func backoffDelay(numFailures uint64) time.Duration {
+	return time.Duration(1<<(numFailures-1)) * backoffBase
+}
+
+// backoff waits before retrying the query which failed the given number of times. Unlike
+// pausing the limiter, it delays only this query.
+func backoff(ctx context.Context, numFailures uint64) error {
+	timer := time.NewTimer(backoffDelay(numFailures))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkTooManyRequests updates the limiter with the quota in the response headers and decides
+// whether to retry the query. Only the queries rejected with HTTP 429, or with HTTP 403 and
+// the exhausted quota, are retried. If the quota is reported, the limiter pauses all the queries
+// until the reset. Otherwise, e.g. on the self-hosted instances, only this query backs off.
+func checkTooManyRequests(response *http.Response, err error, numFailures *uint64,
+	limiter *RateLimiter, remainingKey, resetKey string) int {
+	if response == nil {
+		if err != nil {
+			return responseFail
+		}
+		return responseSuccess
+	}
+	limiter.UpdateFromHeaders(response.Header, remainingKey, resetKey)
+	quota := quotaExhausted(response.Header, remainingKey)
+	if response.StatusCode != http.StatusTooManyRequests &&
+		(response.StatusCode != http.StatusForbidden || !quota) {
+		if err != nil {
+			return responseFail
+		}
+		return responseSuccess
+	}
+	*numFailures++
+	if *numFailures > maxNumFailures {
+		return responseFail
+	}
+	if !quota {
+		return responseBackoff
+	}
+	// the reset may be missing or already passed
+	limiter.Pause(backoffDelay(*numFailures))
+	return responseRetry
+}
@@ -0,0 +1,182 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/google/go-github.v15/github"
+)
+
+func init() {
+	backoffBase = time.Millisecond
+}
+
+// newTestRateLimiter returns the limiter with the clock which the test moves.
+func newTestRateLimiter(rate float64, burst int) (*RateLimiter, *time.Time) {
+	now := time.Unix(1000000, 0)
+	limiter := NewRateLimiter(rate, burst)
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	req := require.New(t)
+	limiter, now := newTestRateLimiter(2, 3)
+	for i := 0; i < 3; i++ {
+		req.Equal(time.Duration(0), limiter.reserve())
+	}
+	req.Equal(500*time.Millisecond, limiter.reserve())
+	*now = now.Add(250 * time.Millisecond)
+	req.Equal(250*time.Millisecond, limiter.reserve())
+	*now = now.Add(250 * time.Millisecond)
+	req.Equal(time.Duration(0), limiter.reserve())
+	*now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		req.Equal(time.Duration(0), limiter.reserve())
+	}
+	req.NotEqual(time.Duration(0), limiter.reserve())
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	req := require.New(t)
+	limiter, _ := newTestRateLimiter(0, 1)
+	for i := 0; i < 100; i++ {
+		req.Equal(time.Duration(0), limiter.reserve())
+	}
+	req.NoError(limiter.Wait(context.Background()))
+}
+
+func TestRateLimiterUpdate(t *testing.T) {
+	req := require.New(t)
+	limiter, now := newTestRateLimiter(0, 1)
+	limiter.Update(0, now.Add(time.Minute))
+	req.Equal(time.Minute, limiter.reserve())
+	*now = now.Add(time.Minute)
+	req.Equal(time.Duration(0), limiter.reserve())
+
+	limiter, now = newTestRateLimiter(10, 1)
+	limiter.Update(30, now.Add(time.Minute))
+	req.Equal(time.Duration(0), limiter.reserve())
+	req.Equal(2*time.Second, limiter.reserve())
+	// the reset in the past is ignored
+	limiter.Update(0, now.Add(-time.Minute))
+	req.Equal(2*time.Second, limiter.reserve())
+	*now = now.Add(time.Minute)
+	req.Equal(time.Duration(0), limiter.reserve())
+	req.Equal(100*time.Millisecond, limiter.reserve())
+}
+
+func TestRateLimiterUpdateFromHeaders(t *testing.T) {
+	req := require.New(t)
+	limiter, now := newTestRateLimiter(0, 1)
+	header := http.Header{}
+	header.Set("X-RateLimit-Remaining", "0")
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(time.Minute).Unix(), 10))
+	limiter.UpdateFromHeaders(header, "X-RateLimit-Remaining", "X-RateLimit-Reset")
+	req.Equal(time.Minute+time.Second, limiter.reserve())
+
+	limiter, _ = newTestRateLimiter(0, 1)
+	header = http.Header{}
+	header.Set("Retry-After", "30")
+	limiter.UpdateFromHeaders(header, "RateLimit-Remaining", "RateLimit-Reset")
+	req.Equal(30*time.Second, limiter.reserve())
+
+	limiter, _ = newTestRateLimiter(0, 1)
+	header = http.Header{}
+	header.Set("X-RateLimit-Remaining", "0")
+	header.Set("X-RateLimit-Reset", "never")
+	limiter.UpdateFromHeaders(header, "X-RateLimit-Remaining", "X-RateLimit-Reset")
+	limiter.UpdateFromHeaders(nil, "X-RateLimit-Remaining", "X-RateLimit-Reset")
+	req.Equal(time.Duration(0), limiter.reserve())
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	req := require.New(t)
+	limiter, _ := newTestRateLimiter(0, 1)
+	limiter.Pause(time.Minute)
+	limiter.Pause(time.Second)
+	req.Equal(time.Minute, limiter.reserve())
+	limiter.Pause(time.Hour)
+	req.Equal(time.Hour, limiter.reserve())
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	limiter := NewRateLimiter(0, 1)
+	limiter.Pause(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, limiter.Wait(ctx))
+}
+
+func TestCheckTooManyRequests(t *testing.T) {
+	req := require.New(t)
+	limiter, _ := newTestRateLimiter(0, 1)
+	var numFailures uint64
+	response := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	// only this query backs off without the quota headers
+	req.Equal(responseBackoff, checkTooManyRequests(
+		response, nil, &numFailures, limiter, "RateLimit-Remaining", "RateLimit-Reset"))
+	req.Equal(time.Duration(0), limiter.reserve())
+	response.Header.Set("Retry-After", "10")
+	req.Equal(responseRetry, checkTooManyRequests(
+		response, nil, &numFailures, limiter, "RateLimit-Remaining", "RateLimit-Reset"))
+	req.Equal(10*time.Second, limiter.reserve())
+	req.Equal(uint64(2), numFailures)
+
+	limiter, _ = newTestRateLimiter(0, 1)
+	response = &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+	req.Equal(responseFail, checkTooManyRequests(response, errors.New("forbidden"),
+		&numFailures, limiter, "RateLimit-Remaining", "RateLimit-Reset"))
+	response.StatusCode = http.StatusServiceUnavailable
+	req.Equal(responseFail, checkTooManyRequests(response, errors.New("unavailable"),
+		&numFailures, limiter, "RateLimit-Remaining", "RateLimit-Reset"))
+	req.Equal(time.Duration(0), limiter.reserve())
+}
+
+func TestCheckResponseBackoff(t *testing.T) {
+	req := require.New(t)
+	limiter, _ := newTestRateLimiter(0, 1)
+	var numFailures uint64
+	response := &github.Response{Response: &http.Response{
+		StatusCode: http.StatusBadGateway, Header: http.Header{}}}
+	req.Equal(responseBackoff, checkResponse(response, nil, &numFailures, limiter))
+	req.Equal(responseBackoff, checkResponse(nil, errors.New("timeout"), &numFailures, limiter))
+	// the other queries are not paused
+	req.Equal(time.Duration(0), limiter.reserve())
+	req.Equal(uint64(2), numFailures)
+
+	response.StatusCode = http.StatusForbidden
+	response.Header.Set("Retry-After", "10")
+	req.Equal(responseRetry, checkResponse(response, nil, &numFailures, limiter))
+	req.Equal(10*time.Second, limiter.reserve())
+}
+
+func TestCheckResponsePassedReset(t *testing.T) {
+	req := require.New(t)
+	limiter, now := newTestRateLimiter(0, 1)
+	var numFailures uint64
+	response := &github.Response{Response: &http.Response{
+		StatusCode: http.StatusForbidden, Header: http.Header{}}}
+	response.Header.Set("X-Ratelimit-Remaining", "0")
+	response.Header.Set("X-Ratelimit-Reset", strconv.FormatInt(now.Unix()-60, 10))
+	for i := uint64(1); i <= maxNumFailures; i++ {
+		req.Equal(responseRetry, checkResponse(response, nil, &numFailures, limiter))
+		// the queries back off instead of retrying right away
+		req.Equal(backoffDelay(i), limiter.reserve())
+		*now = now.Add(backoffDelay(i))
+	}
+	req.Equal(responseFail, checkResponse(response, nil, &numFailures, limiter))
+}
+
+func TestBackoffCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	require.Equal(t, context.Canceled, backoff(ctx, maxNumFailures))
+	require.True(t, time.Since(start) < time.Second)
+}
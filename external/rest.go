@@ -0,0 +1,83 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// restAPI sends the GET requests to a JSON REST API. The requests share the rate limit and
+// are retried if it was hit.
+type restAPI struct {
+	// name is the name of the service in the report.
+	name    string
+	url     string
+	client  *http.Client
+	limiter *RateLimiter
+	// authorize sets the credentials of the request.
+	authorize func(request *http.Request)
+	// remainingKey and resetKey are the headers with the quota, see UpdateFromHeaders.
+	remainingKey string
+	resetKey     string
+}
+
+// get requests the given path, which may include the query, and decodes the response.
+// It returns false if the resource does not exist.
+func (api restAPI) get(ctx context.Context, path string, result interface{}) (bool, error) {
+	var numFailures uint64
+	for { // api rate limit retry loop
+		if err := api.limiter.Wait(ctx); err != nil {
+			return false, err
+		}
+		request, err := http.NewRequest(http.MethodGet, api.url+path, nil)
+		if err != nil {
+			return false, err
+		}
+		api.authorize(request)
+		response, err := api.client.Do(request.WithContext(ctx))
+		reporter.Increment(fmt.Sprintf("total %s API calls", api.name))
+		if err == nil {
+			if response.StatusCode >= 200 && response.StatusCode < 300 {
+				err = json.NewDecoder(response.Body).Decode(result)
+			} else if response.StatusCode != http.StatusNotFound {
+				err = fmt.Errorf("%s API returned HTTP %d", api.name, response.StatusCode)
+			}
+			_, _ = ioutil.ReadAll(response.Body)
+			_ = response.Body.Close()
+		}
+		status := checkTooManyRequests(response, err, &numFailures, api.limiter,
+			api.remainingKey, api.resetKey)
+		if status == responseRetry || status == responseBackoff {
+			reporter.Increment(fmt.Sprintf("%s API calls returning retry", api.name))
+			if status == responseBackoff {
+				if err := backoff(ctx, numFailures); err != nil {
+					return false, err
+				}
+			}
+			continue
+		} else if status == responseFail {
+			reporter.Increment(fmt.Sprintf("%s API calls failed", api.name))
+			return false, err
+		}
+		reporter.Increment(fmt.Sprintf("%s API calls succeeded", api.name))
+		return response.StatusCode != http.StatusNotFound, nil
+	}
+}
+
+// newRepoRe returns the regexp which matches the HTTP and SSH URLs of the repositories
+// on the given host. path is the regexp of the repository path after the host.
+func newRepoRe(host, path string) *regexp.Regexp {
+	return regexp.MustCompile(`(.*://|^)(?:[^@/]+@)?` + regexp.QuoteMeta(host) +
+		`(?::\d+)?[:/]` + path + `(?:\.git)?/?$`)
+}
+
+// trimAPIURL removes the trailing slash from the API URL so that the paths can be appended.
+func trimAPIURL(apiURL string) string {
+	return strings.TrimSuffix(apiURL, "/")
+}
@@ -0,0 +1,223 @@
+package idmatch
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// nameTokens splits the clean name into words without the punctuation,
+// e.g. "j. smith" becomes ["j", "smith"].
+func nameTokens(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// maxFuzzyBlockSize is the maximum number of the names in a block. The bigger blocks are formed
+// by very common words and are skipped to avoid comparing all the pairs in them.
+const maxFuzzyBlockSize = 1000
+
+// nameBlockingKeys returns the blocking keys of the name: each full word, which may be the last
+// name, followed by the sorted first letters of the other words. Only the names which share
+// a key are compared with each other, e.g. "john smith", "j. smith", "jon smith" and
+// "smith john" share the key "smith j", while "john smith" and "john smyth" share "john s".
+// The names without full words have no keys.
+func nameBlockingKeys(tokens []string) []string {
+	var keys []string
+	for i, token := range tokens {
+		if utf8.RuneCountInString(token) == 1 {
+			continue
+		}
+		letters := make([]string, 0, len(tokens)-1)
+		for j, other := range tokens {
+			if j != i {
+				r, _ := utf8.DecodeRuneInString(other)
+				letters = append(letters, string(r))
+			}
+		}
+		sort.Strings(letters)
+		keys = append(keys, token+" "+strings.Join(letters, ""))
+	}
+	return unique(keys)
+}
+
+// fuzzyNameSimilarity returns the similarity of two names split into words. The words are
+// compared pairwise both in the original and in the alphabetical order, so that "smith john"
+// matches "john smith". An initial matches any word which starts with the same letter,
+// e.g. "j" matches "john", but at least one pair of full words must be compared.
+// The similarity is the lowest Jaro-Winkler similarity of the compared words.
+func fuzzyNameSimilarity(tokens1, tokens2 []string) float64 {
+	if len(tokens1) != len(tokens2) {
+		return 0
+	}
+	similarity := alignedNameSimilarity(tokens1, tokens2)
+	sorted1 := append([]string{}, tokens1...)
+	sorted2 := append([]string{}, tokens2...)
+	sort.Strings(sorted1)
+	sort.Strings(sorted2)
+	if sortedSimilarity := alignedNameSimilarity(sorted1, sorted2); sortedSimilarity > similarity {
+		similarity = sortedSimilarity
+	}
+	return similarity
+}
+
+func alignedNameSimilarity(tokens1, tokens2 []string) float64 {
+	similarity := 1.0
+	compared := false
+	for i, token1 := range tokens1 {
+		token2 := tokens2[i]
+		if utf8.RuneCountInString(token1) == 1 || utf8.RuneCountInString(token2) == 1 {
+			r1, _ := utf8.DecodeRuneInString(token1)
+			r2, _ := utf8.DecodeRuneInString(token2)
+			if r1 != r2 {
+				return 0
+			}
+			continue
+		}
+		compared = true
+		if s := jaroWinkler(token1, token2); s < similarity {
+			similarity = s
+		}
+	}
+	if !compared {
+		return 0
+	}
+	return similarity
+}
+
+// hasInitials returns whether any of the name's words is a single letter.
+func hasInitials(tokens []string) bool {
+	for _, token := range tokens {
+		if utf8.RuneCountInString(token) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyNamePair are two similar names in the same block.
+type fuzzyNamePair struct {
+	name1, name2 string
+	similarity   float64
+}
+
+// fuzzyNameHeuristic connects the people whose names are similar but not equal, e.g.
+// "jon smith" and "john smith", "j. smith" and "john smith" or "smith john" and "john smith".
+// The names must consist of at least two words, must not be popular and their similarity must
+// be at least threshold. The identities limit applies. The confidence decreases quadratically
+// with the similarity. The names with initials are not connected if the initials match several
+// full names which are not similar to each other.
+type fuzzyNameHeuristic struct {
+	threshold float64
+}
+
+func (fuzzyNameHeuristic) Name() string {
+	return "fuzzy-name"
+}
+
+func (h fuzzyNameHeuristic) AddEdges(people People, graph *MatchingGraph) error {
+	name2ids := map[string][]int64{}
+	name2tokens := map[string][]string{}
+	blocks := map[string][]string{}
+	people.ForEach(func(id int64, person *Person) bool {
+		for _, name := range person.NamesWithRepos {
+			if name.Repo != "" || graph.Blacklist().isPopularName(name.Name) {
+				continue
+			}
+			if _, exists := name2tokens[name.Name]; !exists {
+				tokens := nameTokens(name.Name)
+				name2tokens[name.Name] = tokens
+				if len(tokens) >= 2 {
+					for _, key := range nameBlockingKeys(tokens) {
+						blocks[key] = append(blocks[key], name.Name)
+					}
+				}
+			}
+			name2ids[name.Name] = append(name2ids[name.Name], id)
+		}
+		return false
+	})
+	keys := make([]string, 0, len(blocks))
+	for key := range blocks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// the same pair of names may share several blocks
+	compared := map[[2]string]struct{}{}
+	var matched []fuzzyNamePair
+	similar := map[[2]string]bool{}
+	// partners are the full names matched to each name with initials
+	partners := map[string][]string{}
+	for _, key := range keys {
+		names := blocks[key]
+		if len(names) > maxFuzzyBlockSize {
+			reporter.Increment("fuzzy name blocks skipped")
+			continue
+		}
+		for i, name1 := range names {
+			for _, name2 := range names[i+1:] {
+				pair := [2]string{name1, name2}
+				if name1 > name2 {
+					pair = [2]string{name2, name1}
+				}
+				if _, exists := compared[pair]; exists {
+					continue
+				}
+				compared[pair] = struct{}{}
+				tokens1, tokens2 := name2tokens[name1], name2tokens[name2]
+				similarity := fuzzyNameSimilarity(tokens1, tokens2)
+				if similarity < h.threshold {
+					continue
+				}
+				matched = append(matched, fuzzyNamePair{name1, name2, similarity})
+				similar[[2]string{name1, name2}] = true
+				similar[[2]string{name2, name1}] = true
+				if hasInitials(tokens1) && !hasInitials(tokens2) {
+					partners[name1] = append(partners[name1], name2)
+				} else if hasInitials(tokens2) && !hasInitials(tokens1) {
+					partners[name2] = append(partners[name2], name1)
+				}
+			}
+		}
+	}
+	// "j. smith" is ambiguous if it matches both "john smith" and "jane smith"
+	ambiguous := func(name string) bool {
+		for i, partner1 := range partners[name] {
+			for _, partner2 := range partners[name][i+1:] {
+				if !similar[[2]string{partner1, partner2}] {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	pairs := 0
+	for _, pair := range matched {
+		if ambiguous(pair.name1) || ambiguous(pair.name2) {
+			continue
+		}
+		pairs++
+		for _, id1 := range name2ids[pair.name1] {
+			for _, id2 := range name2ids[pair.name2] {
+				if id1 == id2 || graph.HasEdge(id1, id2) ||
+					!graph.CompatibleExternalIDs(id1, id2) ||
+					!graph.PassesIdentitiesLimit(id1, id2) {
+					continue
+				}
+				err := graph.AddEdge(Edge{id1, id2, "similar name",
+					pair.name1 + " ~ " + pair.name2,
+					nameConfidence * pair.similarity * pair.similarity})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	reporter.Commit("fuzzy name pairs matched", pairs)
+	return nil
+}
@@ -0,0 +1,155 @@
+package idmatch
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+func TestNameTokens(t *testing.T) {
+	req := require.New(t)
+	req.Equal([]string{"j", "smith"}, nameTokens("j. smith"))
+	req.Equal([]string{"jean", "luc", "picard"}, nameTokens("jean-luc  picard"))
+	req.Len(nameTokens("..."), 0)
+}
+
+func TestNameBlockingKeys(t *testing.T) {
+	req := require.New(t)
+	for _, name := range []string{"john smith", "j. smith", "jon smith", "smith john"} {
+		req.Contains(nameBlockingKeys(nameTokens(name)), "smith j", name)
+	}
+	req.Equal([]string{"john s", "smith j"}, nameBlockingKeys(nameTokens("john smith")))
+	req.Contains(nameBlockingKeys(nameTokens("john smyth")), "john s")
+	req.Equal([]string{"john as", "smith aj"}, nameBlockingKeys(nameTokens("john a. smith")))
+	req.Empty(nameBlockingKeys(nameTokens("j. s.")))
+}
+
+func TestFuzzyNameSimilarity(t *testing.T) {
+	req := require.New(t)
+	similarity := func(name1, name2 string) float64 {
+		return fuzzyNameSimilarity(nameTokens(name1), nameTokens(name2))
+	}
+	req.Equal(1.0, similarity("john smith", "john smith"))
+	req.Equal(1.0, similarity("smith john", "john smith"))
+	req.Equal(1.0, similarity("j. smith", "john smith"))
+	req.Equal(1.0, similarity("smith, j.", "john smith"))
+	req.InDelta(0.933, similarity("jon smith", "john smith"), 0.001)
+	req.InDelta(0.933, similarity("smith jon", "john smith"), 0.001)
+	req.Equal(0.0, similarity("j. s.", "john smith"))
+	req.Equal(0.0, similarity("a. smith", "john smith"))
+	req.Equal(0.0, similarity("john smith", "john a. smith"))
+	req.Equal(0.0, similarity("bob 1", "bob 2"))
+	req.True(similarity("john smith", "joan smyth") < 0.9)
+}
+
+func TestAddEdgesWithFuzzyNames(t *testing.T) {
+	req := require.New(t)
+	people := People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"john smith", ""}}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"jon smith", ""}}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"smith, j.", ""}}},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"jane doe", ""}}},
+		5: {ID: 5, NamesWithRepos: []NameWithRepo{{"j. doe", ""}}, ExternalID: "jd"},
+		6: {ID: 6, NamesWithRepos: []NameWithRepo{{"jane doe", ""}}, ExternalID: "jane"},
+		7: {ID: 7, NamesWithRepos: []NameWithRepo{{"popular name", ""}}},
+		8: {ID: 8, NamesWithRepos: []NameWithRepo{{"popular names", ""}}},
+		9: {ID: 9, NamesWithRepos: []NameWithRepo{{"jon smith", "repo"}}},
+	}
+	blacklist := newTestBlacklist(t)
+	blacklist.PopularNames["popular name"] = struct{}{}
+	graph := newMatchingGraph(people, blacklist, 100)
+	req.NoError(fuzzyNameHeuristic{0.9}.AddEdges(people, graph))
+	req.True(graph.HasEdge(1, 2))
+	req.True(graph.HasEdge(1, 3))
+	req.True(graph.HasEdge(2, 3))
+	req.True(graph.HasEdge(4, 5))
+	req.False(graph.HasEdge(5, 6))
+	req.False(graph.HasEdge(7, 8))
+	req.Equal(0, graph.graph.From(9).Len())
+	req.Equal("jd", people[4].ExternalID)
+
+	// "john smith" and "jon smith" are different people, so "smith, j." can be any of them
+	graph = newMatchingGraph(people, blacklist, 100)
+	req.NoError(fuzzyNameHeuristic{0.95}.AddEdges(people, graph))
+	req.False(graph.HasEdge(1, 2))
+	req.False(graph.HasEdge(1, 3))
+	req.False(graph.HasEdge(2, 3))
+}
+
+func TestAddEdgesWithFuzzyNamesSharedInitial(t *testing.T) {
+	req := require.New(t)
+	people := People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"john smith", ""}}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"jane smith", ""}}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"j. smith", ""}}},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice doe", ""}}},
+		5: {ID: 5, NamesWithRepos: []NameWithRepo{{"a. doe", ""}}},
+	}
+	graph := newMatchingGraph(people, newTestBlacklist(t), 100)
+	req.NoError(fuzzyNameHeuristic{0.9}.AddEdges(people, graph))
+	req.Equal(0, graph.graph.From(3).Len())
+	req.False(graph.HasEdge(1, 2))
+	req.True(graph.HasEdge(4, 5))
+}
+
+func TestAddEdgesWithFuzzyNamesBlockSize(t *testing.T) {
+	req := require.New(t)
+	people := People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"john smith", ""}}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"jon smith", ""}}},
+	}
+	// "smith j" is too big, but "jon smith" and "john smith" do not share other keys
+	for i := 0; i < maxFuzzyBlockSize; i++ {
+		id := int64(i + 3)
+		people[id] = &Person{ID: id, NamesWithRepos: []NameWithRepo{
+			{fmt.Sprintf("j%s smith", strings.Repeat("x", i+1)), ""}}}
+	}
+	graph := newMatchingGraph(people, newTestBlacklist(t), 100)
+	req.NoError(fuzzyNameHeuristic{0.9}.AddEdges(people, graph))
+	req.False(graph.HasEdge(1, 2))
+	value, _ := reporter.Get("fuzzy name blocks skipped")
+	req.Equal(1, value)
+}
+
+func TestReducePeopleFuzzyNames(t *testing.T) {
+	req := require.New(t)
+	newPeople := func() People {
+		return People{
+			1: {ID: 1, NamesWithRepos: []NameWithRepo{{"john smith", ""}},
+				Emails: []string{"john@google.com"}},
+			2: {ID: 2, NamesWithRepos: []NameWithRepo{{"smith john", ""}},
+				Emails: []string{"js@gmail.com"}},
+			3: {ID: 3, NamesWithRepos: []NameWithRepo{{"jon smith", ""}},
+				Emails: []string{"jon@gmail.com"}},
+			4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice", ""}},
+				Emails: []string{"alice@google.com"}},
+		}
+	}
+	blacklist := newTestBlacklist(t)
+	heuristics, err := NewHeuristics(append(DefaultHeuristics, "fuzzy-name"),
+		HeuristicOptions{NameSimilarity: 0.9})
+	req.NoError(err)
+
+	people := newPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{}))
+	req.Len(people, 4)
+
+	people = newPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{Heuristics: heuristics}))
+	req.Equal(People{
+		1: {ID: 1,
+			NamesWithRepos: []NameWithRepo{{"john smith", ""}, {"jon smith", ""}, {"smith john", ""}},
+			Emails:         []string{"john@google.com", "jon@gmail.com", "js@gmail.com"}},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice", ""}},
+			Emails: []string{"alice@google.com"}},
+	}, withoutMatchDetails(people))
+
+	// the identities limit still applies
+	people = newPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 3, ReduceOptions{Heuristics: heuristics}))
+	req.Len(people, 3)
+}
@@ -0,0 +1,242 @@
+package idmatch
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// localRepository is a Git repository on disk together with its identifier.
+type localRepository struct {
+	// ID is the host and the path of the origin remote, e.g. "github.com/src-d/hercules",
+	// so that the external matchers can look up the commits. It is the absolute path
+	// to the repository if there is no such remote.
+	ID   string
+	Path string
+}
+
+// scpLikeURLRe matches the SSH remote URLs in the short form, e.g. git@github.com:src-d/go-git.
+var scpLikeURLRe = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]{2,}):(.+)$`)
+
+// discoverRepositories finds the Git repositories given the list of paths. Each path can point
+// either to a repository, bare or not, or to a directory which contains repositories at any depth.
+func discoverRepositories(paths []string) ([]localRepository, error) {
+	var repos []localRepository
+	seen := map[string]struct{}{}
+	ids := map[string]string{}
+	add := func(path string) {
+		if _, exists := seen[path]; exists {
+			return
+		}
+		seen[path] = struct{}{}
+		id := repositoryID(path)
+		if other, exists := ids[id]; exists {
+			logrus.Warnf("%s and %s have the same origin, identifying the latter by its path",
+				other, path)
+			id = filepath.ToSlash(path)
+		}
+		ids[id] = path
+		repos = append(repos, localRepository{ID: id, Path: path})
+	}
+	for _, root := range paths {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		if isGitRepository(root) {
+			add(root)
+			continue
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() || path == root {
+				return nil
+			}
+			if strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !isGitRepository(path) {
+				return nil
+			}
+			add(path)
+			return filepath.SkipDir
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		if repos[i].ID != repos[j].ID {
+			return repos[i].ID < repos[j].ID
+		}
+		return repos[i].Path < repos[j].Path
+	})
+	return repos, nil
+}
+
+// repositoryID returns the identifier of the repository at path, see localRepository.ID.
+func repositoryID(path string) string {
+	if r, err := git.PlainOpen(path); err == nil {
+		if remote, err := r.Remote("origin"); err == nil && len(remote.Config().URLs) > 0 {
+			if id := remoteRepositoryID(remote.Config().URLs[0]); id != "" {
+				return id
+			}
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// remoteRepositoryID converts the remote URL to the host and the path without ".git".
+// It returns an empty string if the remote is not a URL, e.g. a local path.
+func remoteRepositoryID(remote string) string {
+	var host, path string
+	if strings.Contains(remote, "://") {
+		parsed, err := url.Parse(remote)
+		if err != nil || parsed.Hostname() == "" {
+			return ""
+		}
+		host, path = parsed.Hostname(), parsed.Path
+	} else if match := scpLikeURLRe.FindStringSubmatch(remote); match != nil {
+		host, path = match[1], match[2]
+	} else {
+		return ""
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if path == "" {
+		return ""
+	}
+	return host + "/" + path
+}
+
+func isGitRepository(path string) bool {
+	_, err := git.PlainOpen(path)
+	return err == nil
+}
+
+// streamSignaturesFromRepositories walks all the commits in the local Git repositories and
+// passes to the sink the same records as findPeopleSQL returns: one per unique repository, name
+// and email with the hash and the time of the latest commit. The committer and the trailer
+// signatures are passed as well if the source requests them. The repositories are read one
+// at a time.
+func streamSignaturesFromRepositories(ctx context.Context, source SignatureSource,
+	sink signatureSink) error {
+	paths := source.Repositories
+	repos, err := discoverRepositories(paths)
+	if err != nil {
+		return err
+	}
+	if len(repos) == 0 {
+		return fmt.Errorf("no Git repositories found in %s", strings.Join(paths, ", "))
+	}
+	logrus.Printf("found %d Git repositories", len(repos))
+	reporter.Commit("local repositories found", len(repos))
+
+	spin := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
+	spin.Start()
+	defer spin.Stop()
+	commits := 0
+	for _, repo := range repos {
+		spin.Suffix = fmt.Sprintf(" %s", repo.ID)
+		signatures, n, err := readRepositorySignatures(ctx, repo, source, nil)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", repo.Path, err)
+		}
+		commits += n
+		for _, sig := range signatures {
+			if err := sink(sig); err != nil {
+				return err
+			}
+		}
+	}
+	reporter.Commit("local commits read", commits)
+	return nil
+}
+
+// readRepositorySignatures returns the unique signatures in a single repository and the number
+// of visited commits. The commits are walked from the branches, so the unreachable objects such
+// as the stashes and the rewritten history are skipped. If exclude is not empty, only the commits
+// which are not reachable from the exclude commits are visited.
+func readRepositorySignatures(ctx context.Context, repo localRepository, source SignatureSource,
+	exclude []string) ([]signatureWithRepo, int, error) {
+	r, err := git.PlainOpen(repo.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	latest := latestSignatures{}
+	add := func(c *object.Commit, s object.Signature, role string) {
+		latest.add(signatureWithRepo{
+			repo:  repo.ID,
+			name:  s.Name,
+			email: s.Email,
+			hash:  c.Hash.String(),
+			time:  s.When,
+			role:  role,
+		})
+	}
+	commits := 0
+	visit := func(c *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		commits++
+		add(c, c.Author, roleAuthor)
+		if source.Committers {
+			add(c, c.Committer, roleCommitter)
+		}
+		if source.Trailers {
+			for _, sig := range trailerSignatures(
+				repo.ID, c.Hash.String(), c.Author.When, c.Message) {
+				latest.add(sig)
+			}
+		}
+		return nil
+	}
+	if err = forEachNewCommit(r, exclude, visit); err != nil {
+		return nil, 0, err
+	}
+	return latest.slice(), commits, nil
+}
+
+// forEachNewCommit calls f for each commit which is reachable from the branches but not from
+// the exclude commits. The walk stops at the exclude commits, so the commits which are also
+// reachable from them through a merge may be visited as well.
+func forEachNewCommit(r *git.Repository, exclude []string, f func(*object.Commit) error) error {
+	heads, err := branchHeads(r)
+	if err != nil {
+		return err
+	}
+	seen := map[plumbing.Hash]bool{}
+	for _, hash := range exclude {
+		seen[plumbing.NewHash(hash)] = true
+	}
+	for _, head := range heads {
+		c, err := r.CommitObject(plumbing.NewHash(head))
+		if err != nil {
+			return err
+		}
+		err = object.NewCommitPreorderIter(c, seen, nil).ForEach(func(c *object.Commit) error {
+			seen[c.Hash] = true
+			return f(c)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
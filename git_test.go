@@ -0,0 +1,306 @@
+package idmatch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+type testCommit struct {
+	name  string
+	email string
+	when  time.Time
+	// committer is the same as the author if nil
+	committer *object.Signature
+	message   string
+}
+
+// createTestRepository initializes a Git repository at path and makes the given commits.
+// It returns the hashes of the created commits.
+func createTestRepository(t *testing.T, path string, commits []testCommit) []string {
+	t.Helper()
+	req := require.New(t)
+	repo, err := git.PlainInit(path, false)
+	req.NoError(err)
+	wt, err := repo.Worktree()
+	req.NoError(err)
+	var hashes []string
+	for i, c := range commits {
+		req.NoError(ioutil.WriteFile(filepath.Join(path, "file"), []byte(strconv.Itoa(i)), 0666))
+		_, err = wt.Add("file")
+		req.NoError(err)
+		sig := &object.Signature{Name: c.name, Email: c.email, When: c.when}
+		message := c.message
+		if message == "" {
+			message = "commit " + strconv.Itoa(i)
+		}
+		hash, err := wt.Commit(message, &git.CommitOptions{
+			Author: sig, Committer: c.committer})
+		req.NoError(err)
+		hashes = append(hashes, hash.String())
+	}
+	return hashes
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "idmatch")
+	require.NoError(t, err)
+	return dir, func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func TestDiscoverRepositories(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	now := time.Now()
+	createTestRepository(t, filepath.Join(dir, "src-d", "go-git"),
+		[]testCommit{{"Bob", "bob@google.com", now, nil, ""}})
+	createTestRepository(t, filepath.Join(dir, "hercules"),
+		[]testCommit{{"Bob", "bob@google.com", now, nil, ""}})
+	req.NoError(os.MkdirAll(filepath.Join(dir, "empty", "dir"), 0777))
+
+	repos, err := discoverRepositories([]string{dir})
+	req.NoError(err)
+	req.Equal([]localRepository{
+		{ID: filepath.ToSlash(filepath.Join(dir, "hercules")),
+			Path: filepath.Join(dir, "hercules")},
+		{ID: filepath.ToSlash(filepath.Join(dir, "src-d", "go-git")),
+			Path: filepath.Join(dir, "src-d", "go-git")},
+	}, repos)
+
+	repos, err = discoverRepositories([]string{filepath.Join(dir, "hercules"), dir})
+	req.NoError(err)
+	req.Len(repos, 2)
+	req.Equal(filepath.Join(dir, "hercules"), repos[0].Path)
+
+	repos, err = discoverRepositories([]string{filepath.Join(dir, "empty")})
+	req.NoError(err)
+	req.Len(repos, 0)
+}
+
+func TestDiscoverRepositoriesIDs(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	now := time.Now()
+	var paths []string
+	for _, parent := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, parent, "src")
+		createTestRepository(t, path, []testCommit{{"Bob", "bob@google.com", now, nil, ""}})
+		paths = append(paths, path)
+	}
+	for _, path := range paths[1:] {
+		r, err := git.PlainOpen(path)
+		req.NoError(err)
+		_, err = r.CreateRemote(&config.RemoteConfig{
+			Name: "origin", URLs: []string{"git@github.com:src-d/hercules.git"}})
+		req.NoError(err)
+	}
+
+	repos, err := discoverRepositories(paths)
+	req.NoError(err)
+	req.Equal([]localRepository{
+		{ID: filepath.ToSlash(paths[0]), Path: paths[0]},
+		{ID: filepath.ToSlash(paths[2]), Path: paths[2]},
+		{ID: "github.com/src-d/hercules", Path: paths[1]},
+	}, repos)
+}
+
+func TestRemoteRepositoryID(t *testing.T) {
+	req := require.New(t)
+	for remote, id := range map[string]string{
+		"https://github.com/src-d/hercules":               "github.com/src-d/hercules",
+		"https://user@github.com/src-d/hercules.git":      "github.com/src-d/hercules",
+		"ssh://git@gitlab.com:2222/group/sub/project.git": "gitlab.com/group/sub/project",
+		"git@bitbucket.org:team/project.git":              "bitbucket.org/team/project",
+		"gitea.corp.com:org/repo/":                        "gitea.corp.com/org/repo",
+		"file:///srv/git/hercules.git":                    "",
+		"/srv/git/hercules.git":                           "",
+		"../hercules":                                     "",
+		"https://github.com/":                             "",
+	} {
+		req.Equal(id, remoteRepositoryID(remote), remote)
+	}
+}
+
+// readSignaturesFromRepositories collects all the signatures streamed from the repositories.
+func readSignaturesFromRepositories(ctx context.Context, source SignatureSource) (
+	[]signatureWithRepo, error) {
+	var result []signatureWithRepo
+	err := streamSignaturesFromRepositories(ctx, source, collectSignatures(&result))
+	return result, err
+}
+
+func TestReadSignaturesFromRepositories(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	base := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	hashes1 := createTestRepository(t, filepath.Join(dir, "repo1"), []testCommit{
+		{"Bob", "Bob@google.com", base, nil, ""},
+		{"Alice", "alice@google.com", base.Add(time.Hour), nil, ""},
+		{"Bob", "Bob@google.com", base.Add(2 * time.Hour), nil, ""},
+	})
+	hashes2 := createTestRepository(t, filepath.Join(dir, "repo2"), []testCommit{
+		{"Bob", "Bob@google.com", base.Add(3 * time.Hour), nil, ""},
+	})
+
+	signatures, err := readSignaturesFromRepositories(
+		context.Background(), SignatureSource{Repositories: []string{dir}})
+	req.NoError(err)
+	req.Len(signatures, 3)
+	repo1 := filepath.ToSlash(filepath.Join(dir, "repo1"))
+	repo2 := filepath.ToSlash(filepath.Join(dir, "repo2"))
+	for i := range signatures {
+		signatures[i].time = signatures[i].time.UTC()
+	}
+	req.Equal([]signatureWithRepo{
+		{repo: repo1, name: "Alice", email: "alice@google.com", hash: hashes1[1],
+			time: base.Add(time.Hour), role: roleAuthor},
+		{repo: repo1, name: "Bob", email: "Bob@google.com", hash: hashes1[2],
+			time: base.Add(2 * time.Hour), role: roleAuthor},
+		{repo: repo2, name: "Bob", email: "Bob@google.com", hash: hashes2[0],
+			time: base.Add(3 * time.Hour), role: roleAuthor},
+	}, signatures)
+
+	_, err = readSignaturesFromRepositories(
+		context.Background(), SignatureSource{Repositories: []string{filepath.Join(dir, "nope")}})
+	req.Error(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = readSignaturesFromRepositories(ctx, SignatureSource{Repositories: []string{dir}})
+	req.Error(err)
+}
+
+func TestReadSignaturesFromRepositoriesUnreachable(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "repo")
+	hashes := createTestRepository(t, path, []testCommit{
+		{"Bob", "bob@google.com", time.Now(), nil, ""},
+		{"Eve", "eve@google.com", time.Now(), nil, ""},
+	})
+	repo, err := git.PlainOpen(path)
+	req.NoError(err)
+	req.NoError(repo.Storer.SetReference(
+		plumbing.NewHashReference(plumbing.Master, plumbing.NewHash(hashes[0]))))
+
+	signatures, err := readSignaturesFromRepositories(
+		context.Background(), SignatureSource{Repositories: []string{dir}})
+	req.NoError(err)
+	req.Len(signatures, 1)
+	req.Equal("Bob", signatures[0].name)
+}
+
+func TestFindSignaturesFromRepositories(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	createTestRepository(t, filepath.Join(dir, "repo"), []testCommit{
+		{"Bob", "Bob@google.com", time.Now().AddDate(0, -1, 0), nil, ""},
+	})
+	cache := filepath.Join(dir, "cache.csv")
+	signatures, err := findSignatures(
+		context.Background(), SignatureSource{Repositories: []string{dir}}, cache)
+	req.NoError(err)
+	req.Len(signatures, 1)
+	req.Equal("Bob", signatures[0].name)
+
+	cached, err := readSignaturesFromDisk(cache)
+	req.NoError(err)
+	req.Len(cached, 1)
+	req.Equal(filepath.ToSlash(filepath.Join(dir, "repo")), cached[0].repo)
+	req.Equal("bob@google.com", cached[0].email)
+	req.Equal(signatures[0].hash, cached[0].hash)
+	_, err = os.Stat(cache + ".tmp")
+	req.True(os.IsNotExist(err))
+
+	// the cache is not written if the signatures are not consumed until the end
+	cache = filepath.Join(dir, "broken.csv")
+	err = streamSignatures(context.Background(), SignatureSource{Repositories: []string{dir}},
+		cache, func(signatureWithRepo) error { return errors.New("stop") })
+	req.EqualError(err, "stop")
+	for _, path := range []string{cache, cache + ".tmp"} {
+		_, err = os.Stat(path)
+		req.True(os.IsNotExist(err), path)
+	}
+}
+
+func TestReadSignaturesFromRepositoriesCommitters(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	base := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	merger := &object.Signature{Name: "Maintainer", Email: "maintainer@google.com",
+		When: base.Add(time.Hour)}
+	hashes := createTestRepository(t, filepath.Join(dir, "repo"), []testCommit{
+		{"Bob", "Bob@google.com", base, merger, ""},
+		{"Alice", "alice@google.com", base.Add(2 * time.Hour), nil, ""},
+	})
+
+	signatures, err := readSignaturesFromRepositories(
+		context.Background(), SignatureSource{Repositories: []string{dir}})
+	req.NoError(err)
+	req.Len(signatures, 2)
+
+	signatures, err = readSignaturesFromRepositories(context.Background(),
+		SignatureSource{Repositories: []string{dir}, Committers: true})
+	req.NoError(err)
+	for i := range signatures {
+		signatures[i].time = signatures[i].time.UTC()
+	}
+	repo := filepath.ToSlash(filepath.Join(dir, "repo"))
+	req.Equal([]signatureWithRepo{
+		{repo: repo, name: "Alice", email: "alice@google.com", hash: hashes[1],
+			time: base.Add(2 * time.Hour), role: roleAuthor},
+		{repo: repo, name: "Bob", email: "Bob@google.com", hash: hashes[0],
+			time: base, role: roleAuthor},
+		{repo: repo, name: "Alice", email: "alice@google.com", hash: hashes[1],
+			time: base.Add(2 * time.Hour), role: roleCommitter},
+		{repo: repo, name: "Maintainer", email: "maintainer@google.com", hash: hashes[0],
+			time: base.Add(time.Hour), role: roleCommitter},
+	}, signatures)
+}
+
+func TestReadSignaturesFromRepositoriesTrailers(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	base := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	hashes := createTestRepository(t, filepath.Join(dir, "repo"), []testCommit{
+		{"Bob", "Bob@google.com", base, nil, "Pair programming\n\n" +
+			"Co-authored-by: Alice <alice@google.com>\nSigned-off-by: Bob <Bob@google.com>\n"},
+	})
+
+	signatures, err := readSignaturesFromRepositories(context.Background(),
+		SignatureSource{Repositories: []string{dir}, Trailers: true})
+	req.NoError(err)
+	for i := range signatures {
+		signatures[i].time = signatures[i].time.UTC()
+	}
+	repo := filepath.ToSlash(filepath.Join(dir, "repo"))
+	req.Equal([]signatureWithRepo{
+		{repo: repo, name: "Bob", email: "Bob@google.com", hash: hashes[0],
+			time: base, role: roleAuthor},
+		{repo: repo, name: "Alice", email: "alice@google.com", hash: hashes[0],
+			time: base, role: "co-authored-by"},
+		{repo: repo, name: "Bob", email: "Bob@google.com", hash: hashes[0],
+			time: base, role: "signed-off-by"},
+	}, signatures)
+}
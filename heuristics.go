@@ -0,0 +1,359 @@
+package idmatch
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"gonum.org/v1/gonum/graph/simple"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// Heuristic proposes the edges between the people who are likely to be the same person.
+// ReducePeople runs the heuristics in order and merges the connected components of the graph.
+type Heuristic interface {
+	// Name returns the shorthand of the heuristic, e.g. "email".
+	Name() string
+	// AddEdges connects the people in the graph with MatchingGraph.AddEdge.
+	AddEdges(people People, graph *MatchingGraph) error
+}
+
+// HeuristicOptions are the settings of the built-in heuristics.
+type HeuristicOptions struct {
+	// NameSimilarity is the minimum similarity from 0 to 1 of the names connected by "fuzzy-name".
+	NameSimilarity float64
+	// NameFreqs and EmailFreqs are the frequencies of the names and the emails returned by
+	// FindPeople. The edges by the frequent values are less confident. May be nil.
+	NameFreqs  map[string]*Frequency
+	EmailFreqs map[string]*Frequency
+}
+
+// HeuristicConstructor is the Heuristic constructor function type.
+type HeuristicConstructor func(options HeuristicOptions) Heuristic
+
+// Heuristics is the registered heuristic constructors mapped to shorthands.
+var Heuristics = map[string]HeuristicConstructor{
+	"email": func(options HeuristicOptions) Heuristic { return emailHeuristic{options.EmailFreqs} },
+	"name":  func(options HeuristicOptions) Heuristic { return nameHeuristic{options.NameFreqs} },
+	"single-external-id": func(options HeuristicOptions) Heuristic {
+		return singleExternalIDHeuristic{options.NameFreqs}
+	},
+	"fuzzy-name": func(options HeuristicOptions) Heuristic {
+		return fuzzyNameHeuristic{options.NameSimilarity}
+	},
+	"email-name": func(options HeuristicOptions) Heuristic {
+		return emailNameHeuristic{options.NameFreqs}
+	},
+}
+
+// DefaultHeuristics are the shorthands of the heuristics which ReducePeople runs by default.
+var DefaultHeuristics = []string{"email", "name", "single-external-id"}
+
+// NewHeuristics creates the registered heuristics with the given shorthands in the same order.
+func NewHeuristics(names []string, options HeuristicOptions) ([]Heuristic, error) {
+	var heuristics []Heuristic
+	for _, name := range names {
+		constructor, exists := Heuristics[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown heuristic: %s", name)
+		}
+		heuristics = append(heuristics, constructor(options))
+	}
+	return heuristics, nil
+}
+
+// Edge connects two people in the matching graph.
+type Edge struct {
+	Person1 int64
+	Person2 int64
+	// Reason explains why the people are connected, e.g. "same email".
+	Reason string
+	// Value is the shared value which caused the connection, e.g. the email.
+	Value string
+	// Confidence is the probability from 0 to 1 that the people are the same person.
+	// Zero means that the heuristic does not estimate it and is treated as 1.
+	Confidence float64
+}
+
+// MatchingGraph is the graph of people which are connected by the heuristics.
+type MatchingGraph struct {
+	graph           *simple.WeightedUndirectedGraph
+	blacklist       Blacklist
+	maxIdentities   int
+	externalMatcher bool
+	unmatchedEmails map[string]struct{}
+	// heuristic is the name of the running heuristic.
+	heuristic string
+	// edges are the provenance of every added edge in the order of addition.
+	edges []EdgeProvenance
+	// externalIDs are the external identities of the people before they were propagated over
+	// the edges.
+	externalIDs map[int64]string
+	// cannotLink maps the people to the sides of the cannot-link overrides they have.
+	cannotLink map[int64][]cannotLinkSide
+	// components are the connected components of graph.
+	components *componentSet
+	// previous are the IDs of the people from the previous run which the heuristics must not
+	// connect with each other.
+	previous map[int64]struct{}
+}
+
+func newMatchingGraph(people People, blacklist Blacklist, maxIdentities int) *MatchingGraph {
+	graph := simple.NewWeightedUndirectedGraph(0, 0)
+	externalIDs := map[int64]string{}
+	people.ForEach(func(id int64, person *Person) bool {
+		graph.AddNode(node{person, id})
+		if person.ExternalID != "" {
+			externalIDs[id] = person.ExternalID
+		}
+		return false
+	})
+	return &MatchingGraph{graph: graph, blacklist: blacklist, maxIdentities: maxIdentities,
+		externalIDs: externalIDs, components: newComponentSet(graph, nil)}
+}
+
+// Blacklist returns the identities which should not be matched.
+func (g *MatchingGraph) Blacklist() Blacklist {
+	return g.blacklist
+}
+
+// MatchedExternally returns true if the external matcher found the identity of the email.
+func (g *MatchingGraph) MatchedExternally(email string) bool {
+	if !g.externalMatcher {
+		return false
+	}
+	_, unmatched := g.unmatchedEmails[email]
+	return !unmatched
+}
+
+// HasEdge returns true if the two people are directly connected.
+func (g *MatchingGraph) HasEdge(person1, person2 int64) bool {
+	return g.graph.HasEdgeBetween(person1, person2)
+}
+
+// PassesIdentitiesLimit returns true if the components of both people have fewer unique
+// names and emails than the limit. The edges between such people should not be added unless
+// they are certain, e.g. the people share the email.
+func (g *MatchingGraph) PassesIdentitiesLimit(person1, person2 int64) bool {
+	c1, c2 := g.components.get(person1), g.components.get(person2)
+	if len(c1.emails)+len(c1.names) >= g.maxIdentities ||
+		len(c2.emails)+len(c2.names) >= g.maxIdentities {
+		logrus.Debugf(
+			"above the identities limit: %s (%d emails, %d names) and %s (%d emails, %d names)",
+			g.graph.Node(person1).(node).Value.String(), len(c1.emails), len(c1.names),
+			g.graph.Node(person2).(node).Value.String(), len(c2.emails), len(c2.names))
+		return false
+	}
+	return true
+}
+
+// CompatibleExternalIDs returns false if the people have different external identities and
+// thus must not be connected.
+func (g *MatchingGraph) CompatibleExternalIDs(person1, person2 int64) bool {
+	id1 := g.graph.Node(person1).(node).Value.ExternalID
+	id2 := g.graph.Node(person2).(node).Value.ExternalID
+	return id1 == "" || id2 == "" || id1 == id2
+}
+
+// AddEdge connects two people and propagates the external identity over the joined component.
+// It fails if the people have different external identities. The edge is recorded in
+// the provenance of the merged person. The edges which would join the cannot-link aliases
+// are silently skipped, as well as the heuristic edges between the previous people. If the people
+// are already connected, the confidences are combined.
+func (g *MatchingGraph) AddEdge(edge Edge) error {
+	_, err := g.addEdge(edge)
+	return err
+}
+
+// addEdge is AddEdge which also returns whether the edge was added or silently skipped.
+func (g *MatchingGraph) addEdge(edge Edge) (bool, error) {
+	node1 := g.graph.Node(edge.Person1).(node)
+	node2 := g.graph.Node(edge.Person2).(node)
+	if g.violatesCannotLink(edge.Person1, edge.Person2) {
+		logrus.Debugf("cannot-link rejected %s: %s %s", g.heuristic, edge.Reason, edge.Value)
+		reporter.Increment("edges rejected by cannot-link")
+		return false, nil
+	}
+	if g.betweenPrevious(edge.Person1, edge.Person2) {
+		reporter.Increment("edges between the previous people skipped")
+		return false, nil
+	}
+	if edge.Confidence <= 0 || edge.Confidence > 1 {
+		edge.Confidence = 1
+	}
+	if err := g.setEdge(node1, node2, edge.Confidence); err != nil {
+		return false, err
+	}
+	provenance := EdgeProvenance{
+		Heuristic:  g.heuristic,
+		Reason:     edge.Reason,
+		Value:      edge.Value,
+		Confidence: edge.Confidence,
+		Node1:      newEdgeNode(node1.Value),
+		Node2:      newEdgeNode(node2.Value),
+	}
+	g.edges = append(g.edges, provenance)
+	logrus.Debugf("connected %s", provenance.String())
+	return true, nil
+}
+
+// betweenPrevious returns true if both people are from the previous run and the running
+// heuristic is not forced.
+func (g *MatchingGraph) betweenPrevious(person1, person2 int64) bool {
+	if _, forced := forcedHeuristics[g.heuristic]; forced {
+		return false
+	}
+	_, previous1 := g.previous[person1]
+	_, previous2 := g.previous[person2]
+	return previous1 && previous2
+}
+
+// emailHeuristic connects the people with the same email unless it is popular or the external
+// matcher found its identity.
+type emailHeuristic struct {
+	freqs map[string]*Frequency
+}
+
+func (emailHeuristic) Name() string {
+	return "email"
+}
+
+func (h emailHeuristic) AddEdges(people People, graph *MatchingGraph) error {
+	email2id := map[string]int64{}
+	var err error
+	people.ForEach(func(id int64, person *Person) bool {
+		for _, email := range person.Emails {
+			if graph.MatchedExternally(email) {
+				continue
+			}
+			if graph.Blacklist().isPopularEmail(email) {
+				reporter.Increment("popular emails found")
+				continue
+			}
+			if other, exists := email2id[email]; exists {
+				err = graph.AddEdge(Edge{other, id, "same email", email,
+					rarityConfidence(h.freqs, email, 1, emailHalfConfidenceCount)})
+				if err != nil {
+					return true
+				}
+			} else {
+				email2id[email] = id
+			}
+		}
+		return false
+	})
+	reporter.Commit("people matched by email", len(email2id))
+	return err
+}
+
+// nameHeuristic connects the people with the same name and the same external identity unless
+// the name is popular. The identities limit applies.
+type nameHeuristic struct {
+	freqs map[string]*Frequency
+}
+
+func (nameHeuristic) Name() string {
+	return "name"
+}
+
+func (h nameHeuristic) AddEdges(people People, graph *MatchingGraph) error {
+	name2id := map[string]map[string][]int64{}
+	var err error
+	people.ForEach(func(id int64, person *Person) bool {
+		for _, name := range person.NamesWithRepos {
+			if graph.Blacklist().isPopularName(name.String()) {
+				reporter.Increment("popular names found")
+				continue
+			}
+			sameNameIDs, exists := name2id[name.String()]
+			if !exists {
+				sameNameIDs = map[string][]int64{}
+				name2id[name.String()] = sameNameIDs
+			}
+			sameNameAndExternalIDs, exists := sameNameIDs[person.ExternalID]
+			if !exists {
+				sameNameIDs[person.ExternalID] = []int64{id}
+				continue
+			}
+			for _, other := range sameNameAndExternalIDs {
+				if !graph.PassesIdentitiesLimit(other, id) {
+					continue
+				}
+				err = graph.AddEdge(Edge{other, id, "same name", name.String(),
+					rarityConfidence(h.freqs, name.Name, nameConfidence, nameHalfConfidenceCount)})
+				if err != nil {
+					return true
+				}
+			}
+		}
+		return false
+	})
+	reporter.Commit("people matched by name", len(name2id))
+	return err
+}
+
+// singleExternalIDHeuristic connects the people with the same name if only some of them have
+// the external identity and it is the same. The identities limit applies.
+type singleExternalIDHeuristic struct {
+	freqs map[string]*Frequency
+}
+
+func (singleExternalIDHeuristic) Name() string {
+	return "single-external-id"
+}
+
+func (h singleExternalIDHeuristic) AddEdges(people People, graph *MatchingGraph) error {
+	name2id := map[string]map[string][]int64{}
+	var names []string
+	cleanNames := map[string]string{}
+	people.ForEach(func(id int64, person *Person) bool {
+		for _, name := range person.NamesWithRepos {
+			if graph.Blacklist().isPopularName(name.String()) {
+				continue
+			}
+			sameNameIDs, exists := name2id[name.String()]
+			if !exists {
+				sameNameIDs = map[string][]int64{}
+				name2id[name.String()] = sameNameIDs
+				names = append(names, name.String())
+				cleanNames[name.String()] = name.Name
+			}
+			sameNameIDs[person.ExternalID] = append(sameNameIDs[person.ExternalID], id)
+		}
+		return false
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		externalIDs := name2id[name]
+		if _, exists := externalIDs[""]; !exists || len(externalIDs) != 2 {
+			continue
+		}
+		// link every person to the first one with the external identity instead of all the pairs
+		var representative int64
+		var connected []int64
+		for externalID, ids := range externalIDs {
+			if externalID != "" {
+				representative = ids[0]
+				connected = append(connected, ids[1:]...)
+			} else {
+				connected = append(connected, ids...)
+			}
+		}
+		sort.Slice(connected, func(i, j int) bool { return connected[i] < connected[j] })
+		for _, id := range connected {
+			// the external identities may conflict after the previous edges and it is fine
+			if graph.HasEdge(representative, id) ||
+				!graph.CompatibleExternalIDs(representative, id) ||
+				!graph.PassesIdentitiesLimit(representative, id) {
+				continue
+			}
+			err := graph.AddEdge(Edge{representative, id, "single external id", name,
+				rarityConfidence(h.freqs, cleanNames[name], nameConfidence, nameHalfConfidenceCount)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
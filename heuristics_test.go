@@ -0,0 +1,123 @@
+package idmatch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// employeeHeuristic connects the people whose emails share the employee number, e.g.
+// "e123.bob@corp.com" and "e123@corp.com".
+type employeeHeuristic struct {
+	calls *[]string
+}
+
+func (employeeHeuristic) Name() string {
+	return "employee"
+}
+
+func (h employeeHeuristic) AddEdges(people People, graph *MatchingGraph) error {
+	*h.calls = append(*h.calls, h.Name())
+	number2id := map[string]int64{}
+	people.ForEach(func(id int64, person *Person) bool {
+		for _, email := range person.Emails {
+			number := strings.Split(emailLocalPart(email), ".")[0]
+			if other, exists := number2id[number]; exists && other != id {
+				err := graph.AddEdge(Edge{
+					Person1: other, Person2: id, Reason: "same employee number", Value: number})
+				if err != nil {
+					return true
+				}
+			}
+			number2id[number] = id
+		}
+		return false
+	})
+	return nil
+}
+
+type recordingHeuristic struct {
+	name  string
+	calls *[]string
+}
+
+func (h recordingHeuristic) Name() string {
+	return h.name
+}
+
+func (h recordingHeuristic) AddEdges(People, *MatchingGraph) error {
+	*h.calls = append(*h.calls, h.name)
+	return nil
+}
+
+func TestNewHeuristics(t *testing.T) {
+	req := require.New(t)
+	heuristics, err := NewHeuristics([]string{"name", "email", "fuzzy-name"},
+		HeuristicOptions{NameSimilarity: 0.8})
+	req.NoError(err)
+	req.Len(heuristics, 3)
+	req.Equal("name", heuristics[0].Name())
+	req.Equal("email", heuristics[1].Name())
+	req.Equal(fuzzyNameHeuristic{0.8}, heuristics[2])
+	_, err = NewHeuristics([]string{"email", "nope"}, HeuristicOptions{})
+	req.EqualError(err, "unknown heuristic: nope")
+	for _, name := range DefaultHeuristics {
+		req.Contains(Heuristics, name)
+	}
+	for name, constructor := range Heuristics {
+		req.Equal(name, constructor(HeuristicOptions{}).Name())
+	}
+}
+
+func TestReducePeopleCustomHeuristics(t *testing.T) {
+	req := require.New(t)
+	newPeople := func() People {
+		return People{
+			1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"e123.bob@corp.com"}},
+			2: {ID: 2, NamesWithRepos: []NameWithRepo{{"robert", ""}}, Emails: []string{"e123@corp.com"}},
+			3: {ID: 3, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"e456@corp.com"}},
+		}
+	}
+	blacklist := newTestBlacklist(t)
+	var calls []string
+
+	people := newPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{Heuristics: []Heuristic{
+		recordingHeuristic{"first", &calls}, employeeHeuristic{&calls},
+		recordingHeuristic{"last", &calls}}}))
+	req.Equal([]string{"first", "employee", "last"}, calls)
+	req.Len(people, 2)
+	req.Equal([]string{"e123.bob@corp.com", "e123@corp.com"}, people[1].Emails)
+
+	// the built-in heuristics can be combined with the custom ones
+	people = newPeople()
+	heuristics, err := NewHeuristics([]string{"name"}, HeuristicOptions{})
+	req.NoError(err)
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{
+		Heuristics: append(heuristics, employeeHeuristic{&calls})}))
+	req.Len(people, 1)
+
+	// no heuristics, no merges
+	people = newPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{Heuristics: []Heuristic{}}))
+	req.Len(people, 3)
+}
+
+func TestSingleExternalIDHeuristicStar(t *testing.T) {
+	req := require.New(t)
+	people := People{}
+	for id := int64(1); id <= 5; id++ {
+		people[id] = &Person{ID: id, NamesWithRepos: []NameWithRepo{{"alice cooper", ""}}}
+	}
+	people[2].ExternalID = "alice"
+	people[4].ExternalID = "alice"
+	graph := newMatchingGraph(people, newTestBlacklist(t), 100)
+	req.NoError(singleExternalIDHeuristic{}.AddEdges(people, graph))
+	// every person is linked to the first one with the external identity
+	for _, id := range []int64{1, 3, 4, 5} {
+		req.True(graph.HasEdge(2, id), id)
+	}
+	req.False(graph.HasEdge(1, 3))
+	req.False(graph.HasEdge(4, 5))
+}
@@ -0,0 +1,652 @@
+package idmatch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// repositoryState is what the incremental cache remembers about a repository.
+type repositoryState struct {
+	// Heads are the sorted unique hashes of the commits the branches point to. The next run
+	// fetches only the commits which are not reachable from them, so that the old commits
+	// which arrive with a merge or a fast-forward are not missed.
+	Heads []string
+}
+
+// repositoryStates are the states of the cached repositories indexed by repository_id.
+type repositoryStates map[string]repositoryState
+
+// stateCachePath returns the path to the repository states which belong to the signatures cache.
+func stateCachePath(cachePath string) string {
+	return cachePath + ".state"
+}
+
+func readRepositoryStates(path string) (states repositoryStates, err error) {
+	var file *os.File
+	file, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		errClose := file.Close()
+		if err == nil {
+			err = errClose
+		}
+	}()
+	r := csv.NewReader(file)
+	states = repositoryStates{}
+	header := true
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header {
+			header = false
+			if strings.Join(record, ",") != "repo,heads" {
+				return nil, fmt.Errorf("invalid repository states header: %s",
+					strings.Join(record, ","))
+			}
+			continue
+		}
+		states[record[0]] = repositoryState{Heads: strings.Fields(record[1])}
+	}
+	return states, nil
+}
+
+func storeRepositoryStates(path string, states repositoryStates) (err error) {
+	var file *os.File
+	file, err = os.Create(path)
+	if err != nil {
+		return
+	}
+	defer func() {
+		errClose := file.Close()
+		if err == nil {
+			err = errClose
+		}
+	}()
+	writer := csv.NewWriter(file)
+	defer func() {
+		writer.Flush()
+		if err == nil {
+			err = writer.Error()
+		}
+	}()
+	if err = writer.Write([]string{"repo", "heads"}); err != nil {
+		return
+	}
+	for _, repo := range states.repositories() {
+		err = writer.Write([]string{repo, strings.Join(states[repo].Heads, " ")})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// repositories returns the sorted repository identifiers.
+func (states repositoryStates) repositories() []string {
+	repos := make([]string, 0, len(states))
+	for repo := range states {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+// incrementalSource fetches the signatures of a single repository at a time.
+type incrementalSource interface {
+	// heads returns the sorted unique branch heads of every repository.
+	heads(ctx context.Context) (map[string][]string, error)
+	// reachable returns whether all the commits are still reachable from the repository's
+	// branches, that is, none of them were force-pushed away.
+	reachable(ctx context.Context, repo string, hashes []string) (bool, error)
+	// signatures returns the signatures of the commits which are reachable from the branches
+	// but not from the exclude commits. All the commits are read if exclude is empty.
+	signatures(ctx context.Context, repo string, exclude []string) ([]signatureWithRepo, error)
+	// Close releases the resources.
+	Close() error
+}
+
+// updateSignatures brings the signatures cache at path up to date. It fetches only the new
+// commits of the repositories which were seen before, all the commits of the new and the
+// force-pushed repositories, and forgets the repositories which no longer exist. The cache
+// is rebuilt if it exists without the repository states.
+func updateSignatures(ctx context.Context, source SignatureSource, path string,
+	sink signatureSink) error {
+	if path == "" {
+		return errors.New("the incremental mode requires the cache path")
+	}
+	if source.Query != "" {
+		return errors.New("the incremental mode does not support custom queries")
+	}
+	statePath := stateCachePath(path)
+	replay := func(signatureSink) error { return nil }
+	states := repositoryStates{}
+	if _, err := os.Stat(path); err == nil {
+		if _, err := os.Stat(statePath); os.IsNotExist(err) {
+			logrus.Warnf("%s does not exist, rebuilding the signatures cache", statePath)
+		} else if err != nil {
+			return err
+		} else {
+			logrus.Printf("updating the signatures cache: %s", path)
+			if states, err = readRepositoryStates(statePath); err != nil {
+				return err
+			}
+			replay = func(sink signatureSink) error {
+				return streamSignaturesFromDisk(path, sink)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	} else {
+		logrus.Printf("signatures are not cached in %s, loading them incrementally", path)
+	}
+
+	var inc incrementalSource
+	var err error
+	if len(source.Repositories) > 0 {
+		inc, err = newLocalIncrementalSource(source)
+	} else {
+		inc, err = newDatabaseIncrementalSource(source)
+	}
+	if err != nil {
+		return err
+	}
+	defer inc.Close()
+	cache, err := newSignatureCacheWriter(path)
+	if err != nil {
+		return err
+	}
+	states, err = mergeNewSignatures(ctx, inc, replay, states, func(sig signatureWithRepo) error {
+		if err := cache.write(sig); err != nil {
+			return err
+		}
+		return sink(sig)
+	})
+	if err != nil {
+		cache.abort()
+		return err
+	}
+
+	// the cache goes first: if the states are not written, the next run refetches more
+	// commits than necessary and merges them again
+	logrus.Printf("writing the signatures cache to %s", path)
+	if err := cache.commit(); err != nil {
+		return err
+	}
+	return storeRepositoryStates(statePath, states)
+}
+
+// mergeNewSignatures fetches the new signatures from the source and merges them with the cached
+// ones which replay passes to its sink. The signatures of the unchanged repositories are passed
+// to the sink while the cache is replayed, the rest follow. It returns the new repository states.
+func mergeNewSignatures(ctx context.Context, inc incrementalSource,
+	replay func(signatureSink) error, states repositoryStates, sink signatureSink) (
+	repositoryStates, error) {
+	heads, err := inc.heads(ctx)
+	if err != nil {
+		return nil, err
+	}
+	repos := make([]string, 0, len(heads))
+	for repo := range heads {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	spin := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
+	spin.Start()
+	defer spin.Stop()
+	newStates := repositoryStates{}
+	// the cached repositories are normalized
+	unchangedRepos := map[string]bool{}
+	updatedRepos := map[string]latestSignatures{}
+	var fetched []string
+	exclude := map[string][]string{}
+	cached := map[string]latestSignatures{}
+	var unchanged, updated, reloaded, added int
+	for _, repo := range repos {
+		spin.Suffix = fmt.Sprintf(" %s", repo)
+		normalizedRepo, err := normalizeCacheValue(repo)
+		if err != nil {
+			return nil, err
+		}
+		prev, exists := states[repo]
+		if exists {
+			if stringSlicesEqual(prev.Heads, heads[repo]) {
+				unchanged++
+				unchangedRepos[normalizedRepo] = true
+				newStates[repo] = prev
+				continue
+			}
+			fastForward, err := inc.reachable(ctx, repo, prev.Heads)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check the history of %s: %v", repo, err)
+			}
+			if fastForward {
+				updated++
+				exclude[repo] = prev.Heads
+				cached[repo] = latestSignatures{}
+				updatedRepos[normalizedRepo] = cached[repo]
+			} else {
+				logrus.Printf("%s was force-pushed, reloading all its commits", repo)
+				reloaded++
+			}
+		} else {
+			added++
+		}
+		fetched = append(fetched, repo)
+	}
+
+	err = replay(func(sig signatureWithRepo) error {
+		if unchangedRepos[sig.repo] {
+			return sink(sig)
+		}
+		if latest, exists := updatedRepos[sig.repo]; exists {
+			latest.add(sig)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, repo := range fetched {
+		spin.Suffix = fmt.Sprintf(" %s", repo)
+		signatures, err := inc.signatures(ctx, repo, exclude[repo])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the signatures of %s: %v", repo, err)
+		}
+		latest, exists := cached[repo]
+		if !exists {
+			latest = latestSignatures{}
+		}
+		for _, sig := range signatures {
+			if sig, err = normalizeSignature(sig); err != nil {
+				return nil, err
+			}
+			latest.add(sig)
+		}
+		for _, sig := range latest.slice() {
+			if err := sink(sig); err != nil {
+				return nil, err
+			}
+		}
+		newStates[repo] = repositoryState{Heads: heads[repo]}
+	}
+	removed := 0
+	for repo := range states {
+		if _, exists := heads[repo]; !exists {
+			logrus.Printf("%s no longer exists, forgetting it", repo)
+			removed++
+		}
+	}
+	reporter.Commit("incremental unchanged repositories", unchanged)
+	reporter.Commit("incremental updated repositories", updated)
+	reporter.Commit("incremental force-pushed repositories", reloaded)
+	reporter.Commit("incremental new repositories", added)
+	reporter.Commit("incremental removed repositories", removed)
+	return newStates, nil
+}
+
+// normalizeSignature normalizes the fetched signature the same way as readSignaturesFromDisk
+// does, so that it can be merged with the cached ones.
+func normalizeSignature(sig signatureWithRepo) (signatureWithRepo, error) {
+	var err error
+	for _, field := range []*string{&sig.repo, &sig.email, &sig.hash, &sig.role} {
+		if *field, err = normalizeCacheValue(*field); err != nil {
+			return sig, err
+		}
+	}
+	sig.name = strings.TrimSpace(sig.name)
+	sig.time = sig.time.UTC().Truncate(time.Second)
+	return sig, nil
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// localIncrementalSource reads the signatures from the local Git repositories.
+type localIncrementalSource struct {
+	source SignatureSource
+	repos  map[string]localRepository
+}
+
+func newLocalIncrementalSource(source SignatureSource) (*localIncrementalSource, error) {
+	repos, err := discoverRepositories(source.Repositories)
+	if err != nil {
+		return nil, err
+	}
+	inc := &localIncrementalSource{source: source, repos: map[string]localRepository{}}
+	for _, repo := range repos {
+		inc.repos[repo.ID] = repo
+	}
+	return inc, nil
+}
+
+func (inc *localIncrementalSource) heads(ctx context.Context) (map[string][]string, error) {
+	result := map[string][]string{}
+	for id, repo := range inc.repos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		r, err := git.PlainOpen(repo.Path)
+		if err != nil {
+			return nil, err
+		}
+		hashes, err := branchHeads(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the references of %s: %v", repo.Path, err)
+		}
+		result[id] = hashes
+	}
+	return result, nil
+}
+
+// branchHeads returns the sorted unique hashes of the local and remote branches.
+func branchHeads(r *git.Repository) ([]string, error) {
+	refs, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference &&
+			(ref.Name().IsBranch() || ref.Name().IsRemote()) {
+			hashes = append(hashes, ref.Hash().String())
+		}
+		return nil
+	})
+	return unique(hashes), err
+}
+
+func (inc *localIncrementalSource) reachable(
+	ctx context.Context, repo string, hashes []string) (bool, error) {
+	if len(hashes) == 0 {
+		return true, nil
+	}
+	r, err := git.PlainOpen(inc.repos[repo].Path)
+	if err != nil {
+		return false, err
+	}
+	heads, err := branchHeads(r)
+	if err != nil {
+		return false, err
+	}
+	missing := map[plumbing.Hash]struct{}{}
+	for _, hash := range hashes {
+		missing[plumbing.NewHash(hash)] = struct{}{}
+	}
+	seen := map[plumbing.Hash]bool{}
+	for _, head := range heads {
+		c, err := r.CommitObject(plumbing.NewHash(head))
+		if err != nil {
+			return false, err
+		}
+		err = object.NewCommitPreorderIter(c, seen, nil).ForEach(func(c *object.Commit) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			seen[c.Hash] = true
+			delete(missing, c.Hash)
+			if len(missing) == 0 {
+				return storer.ErrStop
+			}
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+		if len(missing) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (inc *localIncrementalSource) signatures(ctx context.Context, repo string, exclude []string) (
+	[]signatureWithRepo, error) {
+	signatures, _, err := readRepositorySignatures(ctx, inc.repos[repo], inc.source, exclude)
+	return signatures, err
+}
+
+func (inc *localIncrementalSource) Close() error {
+	return nil
+}
+
+const findHeadsSQL = `
+SELECT repository_id, commit_hash
+FROM refs
+WHERE (ref_name = 'HEAD' OR ref_name LIKE 'refs/heads/%' OR ref_name LIKE 'refs/remotes/%');
+`
+
+// incrementalBatchSize is the maximum number of the commit hashes in a single query.
+const incrementalBatchSize = 1000
+
+// databaseIncrementalSource runs the built-in queries restricted to a single repository.
+type databaseIncrementalSource struct {
+	source SignatureSource
+	db     *sql.DB
+}
+
+func newDatabaseIncrementalSource(source SignatureSource) (*databaseIncrementalSource, error) {
+	db, err := sql.Open(source.driver(), source.ConnString)
+	if err != nil {
+		return nil, err
+	}
+	return &databaseIncrementalSource{source: source, db: db}, nil
+}
+
+func (inc *databaseIncrementalSource) heads(ctx context.Context) (map[string][]string, error) {
+	rows, err := inc.db.QueryContext(ctx, findHeadsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := map[string][]string{}
+	for rows.Next() {
+		var repo, hash string
+		if err := rows.Scan(&repo, &hash); err != nil {
+			return nil, err
+		}
+		result[repo] = append(result[repo], hash)
+	}
+	for repo, hashes := range result {
+		result[repo] = unique(hashes)
+	}
+	return result, rows.Err()
+}
+
+func (inc *databaseIncrementalSource) reachable(
+	ctx context.Context, repo string, hashes []string) (bool, error) {
+	if len(hashes) == 0 {
+		return true, nil
+	}
+	filter, args := inc.filter(repo, hashes)
+	var count int
+	err := inc.db.QueryRowContext(ctx,
+		"SELECT COUNT(DISTINCT commit_hash) FROM ref_commits WHERE "+filter+";",
+		args...).Scan(&count)
+	return count == len(hashes), err
+}
+
+func (inc *databaseIncrementalSource) signatures(
+	ctx context.Context, repo string, exclude []string) ([]signatureWithRepo, error) {
+	if len(exclude) == 0 {
+		filter, args := inc.filter(repo, nil)
+		return inc.filteredSignatures(ctx, filter, args)
+	}
+	hashes, err := inc.newCommits(ctx, repo, exclude)
+	if err != nil {
+		return nil, err
+	}
+	var result []signatureWithRepo
+	for start := 0; start < len(hashes); start += incrementalBatchSize {
+		end := start + incrementalBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		filter, args := inc.filter(repo, hashes[start:end])
+		signatures, err := inc.filteredSignatures(ctx, filter, args)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, signatures...)
+	}
+	return result, nil
+}
+
+// filter returns the condition which selects the repository and, if hashes is not empty,
+// those commits in it, together with the bound arguments of the condition.
+func (inc *databaseIncrementalSource) filter(repo string, hashes []string) (
+	string, []interface{}) {
+	args := []interface{}{repo}
+	filter := "repository_id = " + inc.source.placeholder(len(args))
+	if len(hashes) == 0 {
+		return filter, args
+	}
+	placeholders := make([]string, len(hashes))
+	for i, hash := range hashes {
+		args = append(args, hash)
+		placeholders[i] = inc.source.placeholder(len(args))
+	}
+	return filter + " AND commit_hash IN (" + strings.Join(placeholders, ", ") + ")", args
+}
+
+// filteredSignatures runs the built-in queries with the condition.
+func (inc *databaseIncrementalSource) filteredSignatures(ctx context.Context, filter string,
+	args []interface{}) ([]signatureWithRepo, error) {
+	var result []signatureWithRepo
+	for _, query := range inc.source.queries() {
+		query.sql = filterQuery(query.sql, filter)
+		query.args = args
+		err := readQuerySignatures(ctx, inc.db, query, SignatureColumns{},
+			collectSignatures(&result))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if inc.source.Trailers {
+		trailers, err := readTrailersFromDatabase(
+			ctx, inc.db, filterQuery(findTrailersSQL, filter), args...)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, trailers...)
+	}
+	return result, nil
+}
+
+// newCommits returns the hashes of the commits which are reachable from the branches of
+// the repository but not from the exclude commits. The history is walked by commit_parents.
+func (inc *databaseIncrementalSource) newCommits(
+	ctx context.Context, repo string, exclude []string) ([]string, error) {
+	filter, args := inc.filter(repo, nil)
+	rows, err := inc.db.QueryContext(ctx,
+		"SELECT commit_hash, commit_parents FROM commits WHERE "+filter+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	parents := map[string][]string{}
+	for rows.Next() {
+		var hash string
+		var rawParents interface{}
+		if err := rows.Scan(&hash, &rawParents); err != nil {
+			return nil, err
+		}
+		if value := sqlString(rawParents); value != "" {
+			var hashes []string
+			if err := json.Unmarshal([]byte(value), &hashes); err != nil {
+				return nil, fmt.Errorf("invalid parents of commit %s: %v", hash, err)
+			}
+			parents[hash] = hashes
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	heads, err := inc.db.QueryContext(ctx, filterQuery(findHeadsSQL, filter), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer heads.Close()
+	var start []string
+	for heads.Next() {
+		var repo, hash string
+		if err := heads.Scan(&repo, &hash); err != nil {
+			return nil, err
+		}
+		start = append(start, hash)
+	}
+	if err := heads.Err(); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	walk := func(hashes []string, visit func(string)) {
+		stack := append([]string{}, hashes...)
+		for len(stack) > 0 {
+			hash := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			visit(hash)
+			stack = append(stack, parents[hash]...)
+		}
+	}
+	walk(exclude, func(string) {})
+	var result []string
+	walk(start, func(hash string) {
+		result = append(result, hash)
+	})
+	return result, nil
+}
+
+func (inc *databaseIncrementalSource) Close() error {
+	return inc.db.Close()
+}
+
+// filterQuery adds the condition to the WHERE clause of a built-in query.
+func filterQuery(query, condition string) string {
+	if strings.Contains(query, "\nWHERE ") {
+		return strings.Replace(query, "\nWHERE ", "\nWHERE "+condition+" AND ", 1)
+	}
+	if strings.Contains(query, "\nGROUP BY ") {
+		return strings.Replace(query, "\nGROUP BY ", "\nWHERE "+condition+"\nGROUP BY ", 1)
+	}
+	logrus.Panicf("cannot add a filter to the query: %s", query)
+	return ""
+}
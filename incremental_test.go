@@ -0,0 +1,261 @@
+package idmatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+type fakeIncrementalSource struct {
+	headHashes  map[string][]string
+	fastForward map[string]bool
+	// repoSignatures are indexed by repository, each signature hash is the commit
+	repoSignatures map[string][]signatureWithRepo
+	// ancestors are the commits which are reachable from each commit
+	ancestors map[string][]string
+	exclude   map[string][]string
+}
+
+func (inc *fakeIncrementalSource) heads(ctx context.Context) (map[string][]string, error) {
+	return inc.headHashes, nil
+}
+
+func (inc *fakeIncrementalSource) reachable(
+	ctx context.Context, repo string, hashes []string) (bool, error) {
+	return inc.fastForward[repo], nil
+}
+
+func (inc *fakeIncrementalSource) signatures(
+	ctx context.Context, repo string, exclude []string) ([]signatureWithRepo, error) {
+	inc.exclude[repo] = exclude
+	excluded := map[string]bool{}
+	for _, hash := range exclude {
+		for _, ancestor := range inc.ancestors[hash] {
+			excluded[ancestor] = true
+		}
+	}
+	var result []signatureWithRepo
+	for _, sig := range inc.repoSignatures[repo] {
+		if !excluded[sig.hash] {
+			result = append(result, sig)
+		}
+	}
+	return result, nil
+}
+
+func (inc *fakeIncrementalSource) Close() error {
+	return nil
+}
+
+func TestMergeNewSignatures(t *testing.T) {
+	req := require.New(t)
+	day := func(d int) time.Time { return time.Date(2019, 10, d, 0, 0, 0, 0, time.UTC) }
+	sig := func(repo, name string, d int, hash string) signatureWithRepo {
+		return signatureWithRepo{repo: repo, name: name, email: name + "@google.com",
+			hash: hash, time: day(d), role: roleAuthor}
+	}
+	cached := []signatureWithRepo{
+		sig("same", "bob", 1, "a"),
+		sig("forward", "bob", 1, "b"),
+		sig("forward", "alice", 2, "c"),
+		sig("forced", "bob", 2, "d"),
+		sig("removed", "bob", 3, "e"),
+	}
+	states := repositoryStates{
+		"same":    {Heads: []string{"a"}},
+		"forward": {Heads: []string{"c"}},
+		"forced":  {Heads: []string{"d"}},
+		"removed": {Heads: []string{"e"}},
+	}
+	inc := &fakeIncrementalSource{
+		headHashes: map[string][]string{
+			"same": {"a"}, "forward": {"f"}, "forced": {"g"}, "new": {"h"}},
+		fastForward: map[string]bool{"forward": true},
+		repoSignatures: map[string][]signatureWithRepo{
+			"same": {sig("same", "bob", 1, "a")},
+			// carol's commit is older than the cached ones but it was merged later
+			"forward": {sig("forward", "bob", 1, "b"), sig("forward", "alice", 2, "c"),
+				sig("forward", "carol", 1, "i"), sig("forward", "bob", 4, "f")},
+			"forced": {sig("forced", "carol", 1, "g")},
+			"new":    {sig("new", "dave", 5, "h")},
+		},
+		ancestors: map[string][]string{"c": {"b", "c"}},
+		exclude:   map[string][]string{},
+	}
+	replay := func(sink signatureSink) error {
+		for _, sig := range cached {
+			if err := sink(sig); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var result []signatureWithRepo
+	newStates, err := mergeNewSignatures(context.Background(), inc, replay, states,
+		collectSignatures(&result))
+	req.NoError(err)
+	// the unchanged repositories are passed while the cache is replayed
+	req.Equal([]signatureWithRepo{
+		sig("same", "bob", 1, "a"),
+		sig("forced", "carol", 1, "g"),
+		sig("forward", "alice", 2, "c"),
+		sig("forward", "bob", 4, "f"),
+		sig("forward", "carol", 1, "i"),
+		sig("new", "dave", 5, "h"),
+	}, result)
+	req.Equal(repositoryStates{
+		"same":    {Heads: []string{"a"}},
+		"forward": {Heads: []string{"f"}},
+		"forced":  {Heads: []string{"g"}},
+		"new":     {Heads: []string{"h"}},
+	}, newStates)
+	req.Equal(map[string][]string{
+		"forward": {"c"}, "forced": nil, "new": nil}, inc.exclude)
+	for key, expected := range map[string]int{
+		"unchanged": 1, "updated": 1, "force-pushed": 1, "new": 1, "removed": 1} {
+		value, _ := reporter.Get("incremental " + key + " repositories")
+		req.Equal(expected, value, key)
+	}
+}
+
+func TestRepositoryStates(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "cache.csv.state")
+	states := repositoryStates{
+		"src-d/go-git": {Heads: []string{"aaa", "bbb"}},
+		"empty":        {Heads: []string{}},
+	}
+	req.NoError(storeRepositoryStates(path, states))
+	loaded, err := readRepositoryStates(path)
+	req.NoError(err)
+	req.Equal(states, loaded)
+	req.Equal([]string{"empty", "src-d/go-git"}, loaded.repositories())
+}
+
+func TestFilterQuery(t *testing.T) {
+	req := require.New(t)
+	req.Equal(`
+SELECT repository_id, commit_author_name, commit_author_email, MAX(commit_hash), MAX(commit_author_when)
+FROM commits
+WHERE repository_id = 'x'
+GROUP BY repository_id, commit_author_name, commit_author_email;
+`, filterQuery(findPeopleSQL, "repository_id = 'x'"))
+	req.Equal(`
+SELECT repository_id, commit_hash, commit_author_when, commit_message
+FROM commits
+WHERE repository_id = 'x' AND LOWER(commit_message) LIKE '%-by:%';
+`, filterQuery(findTrailersSQL, "repository_id = 'x'"))
+	req.Panics(func() { filterQuery("SELECT 1;", "x") })
+	inc := &databaseIncrementalSource{source: SignatureSource{Driver: "postgres"}}
+	filter, args := inc.filter("x", []string{"aaa", "bbb"})
+	req.Equal("repository_id = $1 AND commit_hash IN ($2, $3)", filter)
+	req.Equal([]interface{}{"x", "aaa", "bbb"}, args)
+	inc.source.Driver = ""
+	filter, args = inc.filter("x", nil)
+	req.Equal("repository_id = ?", filter)
+	req.Equal([]interface{}{"x"}, args)
+}
+
+func TestUpdateSignaturesFromRepositories(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	base := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	reposDir := filepath.Join(dir, "repos")
+	path := filepath.Join(reposDir, "repo")
+	hashes := createTestRepository(t, path, []testCommit{
+		{"Bob", "bob@google.com", base, nil, ""},
+		{"Alice", "alice@google.com", base.Add(time.Hour), nil, ""},
+	})
+	createTestRepository(t, filepath.Join(reposDir, "removed"), []testCommit{
+		{"Carol", "carol@google.com", base, nil, ""},
+	})
+	cache := filepath.Join(dir, "cache.csv")
+	source := SignatureSource{Repositories: []string{reposDir}, Incremental: true}
+
+	signatures, err := findSignatures(context.Background(), source, cache)
+	req.NoError(err)
+	req.Len(signatures, 3)
+	states, err := readRepositoryStates(stateCachePath(cache))
+	req.NoError(err)
+	req.Equal([]string{hashes[1]}, states[filepath.ToSlash(path)].Heads)
+
+	// a new commit which is older than the others and a removed repository
+	req.NoError(os.RemoveAll(filepath.Join(reposDir, "removed")))
+	r, err := git.PlainOpen(path)
+	req.NoError(err)
+	wt, err := r.Worktree()
+	req.NoError(err)
+	newHash, err := wt.Commit("new", &git.CommitOptions{Author: &object.Signature{
+		Name: "Dave", Email: "dave@google.com", When: base.Add(-24 * time.Hour)}})
+	req.NoError(err)
+	signatures, err = findSignatures(context.Background(), source, cache)
+	req.NoError(err)
+	req.Len(signatures, 3)
+	req.Equal("Dave", signatures[2].name)
+	req.Equal(newHash.String(), signatures[2].hash)
+	value, _ := reporter.Get("incremental updated repositories")
+	req.Equal(1, value)
+	value, _ = reporter.Get("incremental removed repositories")
+	req.Equal(1, value)
+
+	// force-push the first commit, the rest become unreachable
+	req.NoError(r.Storer.SetReference(plumbing.NewHashReference(
+		plumbing.NewBranchReferenceName("master"), plumbing.NewHash(hashes[0]))))
+	signatures, err = findSignatures(context.Background(), source, cache)
+	req.NoError(err)
+	req.Len(signatures, 1)
+	req.Equal(hashes[0], signatures[0].hash)
+	value, _ = reporter.Get("incremental force-pushed repositories")
+	req.Equal(1, value)
+
+	// nothing changed
+	cached, err := readSignaturesFromDisk(cache)
+	req.NoError(err)
+	signatures, err = findSignatures(context.Background(), source, cache)
+	req.NoError(err)
+	req.Equal(cached, signatures)
+	value, _ = reporter.Get("incremental unchanged repositories")
+	req.Equal(1, value)
+}
+
+func TestUpdateSignaturesWithoutState(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "repos", "repo")
+	hashes := createTestRepository(t, path, []testCommit{
+		{"Bob", "bob@google.com", time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC), nil, ""},
+	})
+	cache := filepath.Join(dir, "cache.csv")
+	req.NoError(storeSignaturesOnDisk(cache, Signatures))
+	source := SignatureSource{Repositories: []string{filepath.Join(dir, "repos")},
+		Incremental: true}
+	signatures, err := findSignatures(context.Background(), source, cache)
+	req.NoError(err)
+	req.Len(signatures, 1)
+	req.Equal("Bob", signatures[0].name)
+	cached, err := readSignaturesFromDisk(cache)
+	req.NoError(err)
+	req.Equal(signatures, cached)
+	states, err := readRepositoryStates(stateCachePath(cache))
+	req.NoError(err)
+	req.Equal(repositoryStates{filepath.ToSlash(path): {Heads: hashes}}, states)
+
+	_, err = findSignatures(context.Background(), source, "")
+	req.Error(err)
+	_, err = findSignatures(context.Background(),
+		SignatureSource{Query: "SELECT 1", Incremental: true}, cache)
+	req.Error(err)
+}
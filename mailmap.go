@@ -0,0 +1,463 @@
+package idmatch
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// MailmapEntry is a single line of a .mailmap file: the commit identity is mapped to the
+// proper one. See `git help check-mailmap` for the details.
+type MailmapEntry struct {
+	// ProperName is the canonical name. May be empty.
+	ProperName string
+	// ProperEmail is the canonical email. May be empty.
+	ProperEmail string
+	// CommitName is the name which must match together with CommitEmail. May be empty,
+	// in which case only the email is matched.
+	CommitName string
+	// CommitEmail is the email which appears in the commits.
+	CommitEmail string
+}
+
+// Mailmap is the ordered list of .mailmap entries. The first matching entry wins.
+type Mailmap []MailmapEntry
+
+// ParseMailmap reads the .mailmap entries in any of the formats supported by Git:
+//
+//	Proper Name <commit@email.xx>
+//	<proper@email.xx> <commit@email.xx>
+//	Proper Name <proper@email.xx> <commit@email.xx>
+//	Proper Name <proper@email.xx> Commit Name <commit@email.xx>
+//
+// Comments and the lines without emails are ignored.
+func ParseMailmap(r io.Reader) (Mailmap, error) {
+	var result Mailmap
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name1, email1, rest, ok := parseMailmapIdentity(line)
+		if !ok {
+			continue
+		}
+		var entry MailmapEntry
+		if name2, email2, _, ok := parseMailmapIdentity(rest); ok {
+			entry = MailmapEntry{
+				ProperName: name1, ProperEmail: email1, CommitName: name2, CommitEmail: email2}
+		} else {
+			entry = MailmapEntry{ProperName: name1, CommitEmail: email1}
+		}
+		if entry.CommitEmail == "" || entry.ProperName == "" && entry.ProperEmail == "" {
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result, scanner.Err()
+}
+
+// String formats the entry as a .mailmap line.
+func (entry MailmapEntry) String() string {
+	var parts []string
+	if entry.ProperName != "" {
+		parts = append(parts, entry.ProperName)
+	}
+	if entry.ProperEmail != "" {
+		parts = append(parts, "<"+entry.ProperEmail+">")
+	}
+	if entry.CommitName != "" {
+		parts = append(parts, entry.CommitName)
+	}
+	parts = append(parts, "<"+entry.CommitEmail+">")
+	return strings.Join(parts, " ")
+}
+
+// Write formats the entries as a .mailmap file.
+func (mailmap Mailmap) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range mailmap {
+		if _, err := fmt.Fprintln(bw, entry.String()); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// parseMailmapIdentity splits "Name <email> rest" into the parts. The name may be empty.
+func parseMailmapIdentity(s string) (name, email, rest string, ok bool) {
+	left := strings.IndexByte(s, '<')
+	if left < 0 {
+		return "", "", "", false
+	}
+	right := strings.IndexByte(s[left+1:], '>')
+	if right < 0 {
+		return "", "", "", false
+	}
+	right += left + 1
+	return strings.TrimSpace(s[:left]), strings.TrimSpace(s[left+1 : right]), s[right+1:], true
+}
+
+// ReadMailmap loads the .mailmap entries from the file at path.
+func ReadMailmap(path string) (mailmap Mailmap, err error) {
+	var file *os.File
+	file, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		errClose := file.Close()
+		if err == nil {
+			err = errClose
+		}
+	}()
+	return ParseMailmap(file)
+}
+
+const findMailmapsSQL = `
+SELECT f.repository_id, f.blob_content
+FROM refs r
+NATURAL JOIN commit_files cf
+NATURAL JOIN files f
+WHERE r.ref_name = 'HEAD' AND cf.file_path = '.mailmap';
+`
+
+// FindMailmaps loads the .mailmap files at HEAD of every repository in the source.
+// The database must be gitbase: the files are not available through the other drivers.
+func FindMailmaps(ctx context.Context, source SignatureSource) (Mailmap, error) {
+	var mailmap Mailmap
+	var err error
+	if len(source.Repositories) > 0 {
+		mailmap, err = readMailmapsFromRepositories(ctx, source.Repositories)
+	} else {
+		mailmap, err = readMailmapsFromDatabase(ctx, source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	reporter.Commit("repository mailmap entries", len(mailmap))
+	return mailmap, nil
+}
+
+func readMailmapsFromRepositories(ctx context.Context, paths []string) (Mailmap, error) {
+	repos, err := discoverRepositories(paths)
+	if err != nil {
+		return nil, err
+	}
+	var result Mailmap
+	for _, repo := range repos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		contents, err := readHeadMailmap(repo.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the mailmap of %s: %v", repo.Path, err)
+		}
+		if contents == "" {
+			continue
+		}
+		mailmap, err := ParseMailmap(strings.NewReader(contents))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the mailmap of %s: %v", repo.Path, err)
+		}
+		logrus.Printf("read %d mailmap entries from %s", len(mailmap), repo.ID)
+		result = append(result, mailmap...)
+	}
+	return result, nil
+}
+
+// readHeadMailmap returns the contents of .mailmap at HEAD or an empty string if there is none.
+func readHeadMailmap(path string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", err
+	}
+	head, err := r.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	c, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return "", err
+	}
+	file, err := c.File(".mailmap")
+	if err == object.ErrFileNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return file.Contents()
+}
+
+func readMailmapsFromDatabase(ctx context.Context, source SignatureSource) (Mailmap, error) {
+	if source.driver() != "mysql" {
+		return nil, fmt.Errorf("the repository mailmaps are only read from gitbase or local "+
+			"repositories, not through the %s driver", source.driver())
+	}
+	db, err := sql.Open(source.driver(), source.ConnString)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(ctx, findMailmapsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result Mailmap
+	for rows.Next() {
+		var repo string
+		var contents []byte
+		if err := rows.Scan(&repo, &contents); err != nil {
+			return nil, err
+		}
+		mailmap, err := ParseMailmap(strings.NewReader(string(contents)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the mailmap of %s: %v", repo, err)
+		}
+		result = append(result, mailmap...)
+	}
+	return result, rows.Err()
+}
+
+// addEdgesWithMailmap connects the identities which are stated to belong together in the
+// mailmap. Those edges are forced: they ignore the identities limit and the blacklists.
+// It returns the index of the first matching mailmap entry for each affected node.
+func addEdgesWithMailmap(people People, graph *MatchingGraph, mailmap Mailmap) (
+	map[int64]int, error) {
+	email2ids := map[string][]int64{}
+	people.ForEach(func(index int64, person *Person) bool {
+		for _, email := range person.Emails {
+			email2ids[email] = append(email2ids[email], index)
+		}
+		return false
+	})
+	hasName := func(id int64, name string) bool {
+		for _, nameWithRepo := range people[id].NamesWithRepos {
+			if nameWithRepo.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	entries := map[int64]int{}
+	edges := 0
+	for i, entry := range mailmap {
+		commitEmail, err := cleanEmail(entry.CommitEmail)
+		if err != nil {
+			return nil, err
+		}
+		commitName, err := cleanName(entry.CommitName)
+		if err != nil {
+			return nil, err
+		}
+		properEmail, err := cleanEmail(entry.ProperEmail)
+		if err != nil {
+			return nil, err
+		}
+		var matched []int64
+		for _, id := range email2ids[commitEmail] {
+			if commitName == "" || hasName(id, commitName) {
+				matched = append(matched, id)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		others := matched[1:]
+		if properEmail != "" && properEmail != commitEmail {
+			others = append(others, email2ids[properEmail]...)
+		}
+		// the entry applies to the nodes which are joined with the first matched node
+		record := func(id int64) {
+			if _, exists := entries[id]; !exists {
+				entries[id] = i
+			}
+		}
+		record(matched[0])
+		for _, id := range others {
+			if id == matched[0] {
+				continue
+			}
+			if graph.HasEdge(id, matched[0]) {
+				record(id)
+				continue
+			}
+			added, err := graph.addEdge(Edge{matched[0], id, "mailmap", entry.String(), 1})
+			if err != nil {
+				logrus.Warnf("ignored mailmap entry %d: %v", i+1, err)
+				continue
+			}
+			if added {
+				edges++
+				record(id)
+			}
+		}
+	}
+	reporter.Commit("mailmap edges", edges)
+	return entries, nil
+}
+
+// setMailmapPrimaryValues sets the primary name and email of the merged person to the proper
+// identity of the first mailmap entry which matched any of the merged nodes.
+func setMailmapPrimaryValues(person *Person, ids []int64, entries map[int64]int,
+	mailmap Mailmap) error {
+	best := -1
+	for _, id := range ids {
+		if i, exists := entries[id]; exists && (best < 0 || i < best) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	entry := mailmap[best]
+	if entry.ProperName != "" {
+		name, err := cleanName(entry.ProperName)
+		if err != nil {
+			return err
+		}
+		person.PrimaryName = name
+		person.RawNames = mergeRoles(person.RawNames, map[string][]string{name: {entry.ProperName}})
+	}
+	if entry.ProperEmail != "" {
+		email, err := cleanEmail(entry.ProperEmail)
+		if err != nil {
+			return err
+		}
+		person.PrimaryEmail = email
+	}
+	return nil
+}
+
+// Mailmap returns the .mailmap entries which map every alias email of every person to the
+// person's primary name and email. If repo is empty, the entries are global. Otherwise, only
+// the people seen in repo are included, see seenIn. Git maps the commits by email regardless of
+// the commit name, so the entries of the emails already cover all the alias names and the names
+// are not written separately. The proper name is written the way it was spelled in the commits,
+// see Person.RawNames.
+func (p People) Mailmap(repo string) Mailmap {
+	var result Mailmap
+	p.ForEach(func(_ int64, person *Person) bool {
+		if person.PrimaryEmail == "" || repo != "" && !person.seenIn(repo) {
+			return false
+		}
+		properName := person.rawName(person.PrimaryName)
+		for _, email := range person.Emails {
+			if email != person.PrimaryEmail {
+				result = append(result, MailmapEntry{
+					ProperName: properName, ProperEmail: person.PrimaryEmail,
+					CommitEmail: email})
+			} else if properName != "" {
+				result = append(result, MailmapEntry{
+					ProperName: properName, CommitEmail: email})
+			}
+		}
+		return false
+	})
+	return result
+}
+
+// rawName returns the spelling of the clean name in the commits. The capitalized spellings
+// are preferred because they go first in the sorted order. It returns the clean name if it
+// was never seen, e.g. the person was loaded from Parquet.
+func (p *Person) rawName(name string) string {
+	if spellings := p.RawNames[name]; len(spellings) > 0 {
+		return spellings[0]
+	}
+	return name
+}
+
+// seenIn returns whether any of the person's identities appeared in the repository.
+func (p *Person) seenIn(repo string) bool {
+	if stringInSlice(p.Repos, repo) {
+		return true
+	}
+	for _, name := range p.NamesWithRepos {
+		if name.Repo == repo {
+			return true
+		}
+	}
+	return false
+}
+
+// repositories returns the sorted list of all the repositories where the people were seen.
+func (p People) repositories() []string {
+	var repos []string
+	for _, person := range p {
+		repos = append(repos, person.Repos...)
+		for _, name := range person.NamesWithRepos {
+			if name.Repo != "" {
+				repos = append(repos, name.Repo)
+			}
+		}
+	}
+	return unique(repos)
+}
+
+// WriteMailmap saves the global .mailmap with all the people to path.
+func (p People) WriteMailmap(path string) error {
+	return writeMailmap(path, p.Mailmap(""))
+}
+
+// WriteRepositoryMailmaps saves a separate .mailmap for each repository to
+// <dir>/<repository>/.mailmap. The absolute repository paths are written relative to dir.
+func (p People) WriteRepositoryMailmaps(dir string) error {
+	for _, repo := range p.repositories() {
+		path, err := repositoryMailmapPath(dir, repo)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := writeMailmap(path, p.Mailmap(repo)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repositoryMailmapPath returns the path to the .mailmap of the repository inside dir.
+// It fails if the repository identifier points outside dir.
+func repositoryMailmapPath(dir, repo string) (string, error) {
+	rel := filepath.FromSlash(repo)
+	rel = strings.TrimPrefix(rel, filepath.VolumeName(rel))
+	rel = filepath.Clean(strings.TrimLeft(rel, string(filepath.Separator)))
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid repository for the mailmap: %s", repo)
+	}
+	return filepath.Join(dir, rel, ".mailmap"), nil
+}
+
+func writeMailmap(path string, mailmap Mailmap) (err error) {
+	var file *os.File
+	file, err = os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		errClose := file.Close()
+		if err == nil {
+			err = errClose
+		}
+	}()
+	return mailmap.Write(file)
+}
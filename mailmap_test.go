@@ -0,0 +1,193 @@
+package idmatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const testMailmap = `# comment
+Bob Smith <bob@google.com>
+<alice@google.com> <alice@localhost>
+Alice <alice@google.com> <alice@users.noreply.github.com> # trailing comment
+Bob Smith <bob@google.com> bobby <bobby@gmail.com>
+
+broken line without emails
+<bob@google.com>
+`
+
+func TestParseMailmap(t *testing.T) {
+	req := require.New(t)
+	mailmap, err := ParseMailmap(strings.NewReader(testMailmap))
+	req.NoError(err)
+	req.Equal(Mailmap{
+		{ProperName: "Bob Smith", CommitEmail: "bob@google.com"},
+		{ProperEmail: "alice@google.com", CommitEmail: "alice@localhost"},
+		{ProperName: "Alice", ProperEmail: "alice@google.com",
+			CommitEmail: "alice@users.noreply.github.com"},
+		{ProperName: "Bob Smith", ProperEmail: "bob@google.com",
+			CommitName: "bobby", CommitEmail: "bobby@gmail.com"},
+	}, mailmap)
+}
+
+func TestReadMailmap(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, ".mailmap")
+	req.NoError(ioutil.WriteFile(path, []byte(testMailmap), 0666))
+	mailmap, err := ReadMailmap(path)
+	req.NoError(err)
+	req.Len(mailmap, 4)
+	_, err = ReadMailmap(filepath.Join(dir, "nope"))
+	req.Error(err)
+}
+
+func TestFindMailmapsFromRepositories(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	now := time.Now()
+	createTestRepository(t, filepath.Join(dir, "without"),
+		[]testCommit{{"Bob", "bob@google.com", now, nil, ""}})
+	path := filepath.Join(dir, "with")
+	createTestRepository(t, path, []testCommit{{"Bob", "bob@google.com", now, nil, ""}})
+	repo, err := git.PlainOpen(path)
+	req.NoError(err)
+	wt, err := repo.Worktree()
+	req.NoError(err)
+	req.NoError(ioutil.WriteFile(filepath.Join(path, ".mailmap"), []byte(testMailmap), 0666))
+	_, err = wt.Add(".mailmap")
+	req.NoError(err)
+	_, err = wt.Commit("add mailmap", &git.CommitOptions{
+		Author: &object.Signature{Name: "Bob", Email: "bob@google.com", When: now}})
+	req.NoError(err)
+
+	mailmap, err := FindMailmaps(context.Background(), SignatureSource{Repositories: []string{dir}})
+	req.NoError(err)
+	req.Len(mailmap, 4)
+	req.Equal(MailmapEntry{ProperName: "Bob Smith", CommitEmail: "bob@google.com"}, mailmap[0])
+}
+
+func TestFindMailmapsUnsupportedDriver(t *testing.T) {
+	_, err := FindMailmaps(context.Background(),
+		SignatureSource{Driver: "sqlite3", ConnString: ":memory:"})
+	require.EqualError(t, err, "the repository mailmaps are only read from gitbase or local "+
+		"repositories, not through the sqlite3 driver")
+}
+
+func TestMailmapWrite(t *testing.T) {
+	req := require.New(t)
+	mailmap, err := ParseMailmap(strings.NewReader(testMailmap))
+	req.NoError(err)
+	buffer := &strings.Builder{}
+	req.NoError(mailmap.Write(buffer))
+	req.Equal(`Bob Smith <bob@google.com>
+<alice@google.com> <alice@localhost>
+Alice <alice@google.com> <alice@users.noreply.github.com>
+Bob Smith <bob@google.com> bobby <bobby@gmail.com>
+`, buffer.String())
+	parsed, err := ParseMailmap(strings.NewReader(buffer.String()))
+	req.NoError(err)
+	req.Equal(mailmap, parsed)
+}
+
+func newTestMailmapPeople() People {
+	return People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}, {"admin", "repo2"}},
+			Emails: []string{"bob@google.com", "bob@gmail.com"}, Repos: []string{"repo1", "repo2"},
+			PrimaryName: "bob", PrimaryEmail: "bob@google.com"},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"alice", ""}},
+			Emails: []string{"alice@google.com"}, Repos: []string{"repo1"},
+			PrimaryName: "alice", PrimaryEmail: "alice@google.com"},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"nobody", ""}}, Emails: []string{"x@x.com"}},
+	}
+}
+
+func TestPeopleMailmap(t *testing.T) {
+	req := require.New(t)
+	people := newTestMailmapPeople()
+	req.Equal(Mailmap{
+		{ProperName: "bob", CommitEmail: "bob@google.com"},
+		{ProperName: "bob", ProperEmail: "bob@google.com", CommitEmail: "bob@gmail.com"},
+		{ProperName: "alice", CommitEmail: "alice@google.com"},
+	}, people.Mailmap(""))
+	req.Equal(Mailmap{
+		{ProperName: "bob", CommitEmail: "bob@google.com"},
+		{ProperName: "bob", ProperEmail: "bob@google.com", CommitEmail: "bob@gmail.com"},
+	}, people.Mailmap("repo2"))
+	req.Len(people.Mailmap("repo1"), 3)
+	req.Len(people.Mailmap("repo3"), 0)
+}
+
+func TestWriteMailmaps(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	people := newTestMailmapPeople()
+
+	path := filepath.Join(dir, "global.mailmap")
+	req.NoError(people.WriteMailmap(path))
+	mailmap, err := ReadMailmap(path)
+	req.NoError(err)
+	req.Equal(people.Mailmap(""), mailmap)
+
+	req.NoError(people.WriteRepositoryMailmaps(filepath.Join(dir, "repos")))
+	for _, repo := range []string{"repo1", "repo2"} {
+		mailmap, err := ReadMailmap(filepath.Join(dir, "repos", repo, ".mailmap"))
+		req.NoError(err)
+		req.Equal(people.Mailmap(repo), mailmap)
+	}
+}
+
+func TestPeopleMailmapRawNames(t *testing.T) {
+	req := require.New(t)
+	people, err := newPeople([]signatureWithRepo{
+		{repo: "repo1", name: "José Smith", email: "Jose@google.com", hash: "aaa"},
+		{repo: "repo1", name: " Jose Smith", email: "jose@gmail.com", hash: "bbb"},
+	}, newTestBlacklist(t))
+	req.NoError(err)
+	_, err = people.Merge(1, 2)
+	req.NoError(err)
+	req.Equal(map[string][]string{"jose smith": {"Jose Smith", "José Smith"}}, people[1].RawNames)
+	people[1].PrimaryName = "jose smith"
+	people[1].PrimaryEmail = "jose@google.com"
+	req.Equal(Mailmap{
+		{ProperName: "Jose Smith", ProperEmail: "jose@google.com", CommitEmail: "jose@gmail.com"},
+		{ProperName: "Jose Smith", CommitEmail: "jose@google.com"},
+	}, people.Mailmap(""))
+}
+
+func TestRepositoryMailmapPath(t *testing.T) {
+	req := require.New(t)
+	dir := filepath.Join("out", "mailmaps")
+	for repo, expected := range map[string]string{
+		"github.com/src-d/hercules": filepath.Join(dir, "github.com", "src-d", "hercules"),
+		"/home/bob/hercules":        filepath.Join(dir, "home", "bob", "hercules"),
+		"src-d/../hercules":         filepath.Join(dir, "hercules"),
+	} {
+		path, err := repositoryMailmapPath(dir, repo)
+		req.NoError(err, repo)
+		req.Equal(filepath.Join(expected, ".mailmap"), path)
+	}
+	for _, repo := range []string{"..", "../hercules", "src-d/../../hercules", "/..", "", "."} {
+		_, err := repositoryMailmapPath(dir, repo)
+		req.Error(err, repo)
+	}
+
+	tmp, cleanup := tempDir(t)
+	defer cleanup()
+	people := newTestMailmapPeople()
+	people[2].Repos = []string{"../../evil"}
+	req.Error(people.WriteRepositoryMailmaps(filepath.Join(tmp, "repos")))
+	_, err := os.Stat(filepath.Join(tmp, "evil"))
+	req.True(os.IsNotExist(err))
+}
@@ -7,10 +7,6 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"gonum.org/v1/gonum/floats"
-	simplegraph "gonum.org/v1/gonum/graph"
-	"gonum.org/v1/gonum/graph/simple"
-	"gonum.org/v1/gonum/graph/topo"
-	"gonum.org/v1/gonum/graph/traverse"
 	"gonum.org/v1/gonum/stat"
 
 	"github.com/src-d/identity-matching/external"
@@ -37,189 +33,214 @@ func (p Int64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (p Int64Slice) Sort() { sort.Sort(p) }
 
 // addEdgesWithMatcher adds edges by the ground truth from an external matcher.
-func addEdgesWithMatcher(people People, peopleGraph *simple.UndirectedGraph,
-	matcher external.Matcher) (map[string]struct{}, error) {
+func addEdgesWithMatcher(people People, graph *MatchingGraph, matcher external.Matcher) error {
 	unprocessedEmails := map[string]struct{}{}
+	graph.externalMatcher = true
+	graph.unmatchedEmails = unprocessedEmails
 	// Add edges by the groundtruth fetched with external matcher.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	username2extID := make(map[string]node)
-	var username string
+	username2extID := make(map[string]int64)
 	var err error
 	noMatchWarned := map[string]struct{}{}
-	for index, person := range people {
+	// the previous people were looked up by the previous run
+	previousEmails := map[string]struct{}{}
+	for id := range graph.previous {
+		for _, email := range people[id].Emails {
+			previousEmails[email] = struct{}{}
+		}
+	}
+	// the emails are queried concurrently and the results are applied in the order of the IDs
+	// of the people to make the edges deterministic
+	var queries []external.Query
+	people.ForEach(func(index int64, person *Person) bool {
 		for _, email := range person.Emails {
-			if matcher.SupportsMatchingByCommit() && person.SampleCommit != nil {
-				username, err = matcher.MatchByCommit(
-					ctx, email, person.SampleCommit.Repo, person.SampleCommit.Hash)
+			if _, exists := previousEmails[email]; exists {
+				continue
+			}
+			query := external.Query{Email: email}
+			if person.SampleCommit != nil {
+				query.Repo = person.SampleCommit.Repo
+				query.Commit = person.SampleCommit.Hash
+			}
+			queries = append(queries, query)
+		}
+		return false
+	})
+	results := external.MatchAll(ctx, matcher, queries)
+	people.ForEach(func(index int64, person *Person) bool {
+		if _, exists := graph.previous[index]; exists && person.ExternalID != "" {
+			if val, ok := username2extID[person.ExternalID]; ok {
+				err = graph.AddEdge(Edge{val, index, "same external id", person.ExternalID, 1})
+				if err != nil {
+					return true
+				}
 			} else {
-				username, err = matcher.MatchByEmail(ctx, email)
+				username2extID[person.ExternalID] = index
 			}
-			if err != nil {
-				if err == external.ErrNoMatches {
+		}
+		for _, email := range person.Emails {
+			if _, exists := previousEmails[email]; exists {
+				unprocessedEmails[email] = struct{}{}
+				reporter.Increment("external API emails skipped")
+				continue
+			}
+			username, matchErr := results[0].User, results[0].Err
+			results = results[1:]
+			if matchErr != nil {
+				if matchErr == external.ErrNoMatches {
 					pstr := person.String()
 					if _, exists := noMatchWarned[pstr]; !exists {
 						noMatchWarned[pstr] = struct{}{}
 						logrus.Warnf("no matches for person %s", pstr)
 					}
 				} else {
-					logrus.Errorf("unexpected error for person %s: %v", person.String(), err)
+					logrus.Errorf("unexpected error for person %s: %v", person.String(), matchErr)
 				}
 				unprocessedEmails[email] = struct{}{}
 			} else {
 				if person.ExternalID != "" && username != person.ExternalID {
-					return unprocessedEmails, fmt.Errorf(
+					err = fmt.Errorf(
 						"person %s has emails with different external ids: %s %s",
 						person.String(), person.ExternalID, username)
+					return true
 				}
 				person.ExternalID = username
 				if val, ok := username2extID[username]; ok {
-					err := setEdge(peopleGraph, val, peopleGraph.Node(index).(node))
+					err = graph.AddEdge(Edge{val, index, "same external id", username, 1})
 					if err != nil {
-						return unprocessedEmails, nil
+						return true
 					}
 				} else {
-					username2extID[username] = peopleGraph.Node(int64(index)).(node)
+					username2extID[username] = index
 				}
 				reporter.Increment("external API emails found")
 			}
 		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	for index, person := range people {
+		if person.ExternalID != "" {
+			graph.externalIDs[index] = person.ExternalID
+		}
 	}
 	err = matcher.OnIdle()
 	reporter.Commit("external API components", len(username2extID))
 	reporter.Commit("external API emails not found", len(unprocessedEmails))
-	return unprocessedEmails, err
+	return err
+}
+
+// ReduceOptions are the optional inputs of ReducePeople. The zero value runs DefaultHeuristics
+// without the mailmap and the overrides and keeps all the edges and components.
+type ReduceOptions struct {
+	// Mailmap are the identities which must be connected, see ParseMailmap.
+	Mailmap Mailmap
+	// Heuristics run in order after the external matching, the overrides and the mailmap.
+	// DefaultHeuristics run if it is nil.
+	Heuristics []Heuristic
+	// Overrides are the manual must-link, cannot-link and primary identity rules.
+	Overrides *Overrides
+	// Components are the post-processing options of the connected components.
+	Components ComponentOptions
 }
 
 // ReducePeople merges the identities together by following the fixed set of rules.
 // 1. Run the external matching, if available.
-// 2. Run the series of heuristics on those items which were left untouched in the list (everything
-//    in case of ext == nil, not found in case of ext != nil).
-//
-// The heuristics are:
-// TODO(vmarkovtsev): describe the current approach
+// 2. Connect the must-link aliases from the overrides, if any.
+// 3. Connect the identities stated in the mailmap, if any. The primary names and emails
+//    of the resulting people are set to the proper identities from the mailmap.
+// 4. Run the heuristics in order on those items which were left untouched in the list (everything
+//    in case of ext == nil, not found in case of ext != nil), see ReduceOptions.Heuristics.
+// 5. Remove the edges with the confidence below the minimum and split the oversized components
+//    with weakly connected communities, see ComponentOptions.
+// 6. Merge the people in each connected component and set the pinned primary names and emails
+//    from the overrides. The confidence of each person is set, see Person.Confidence.
+// No edge ever joins the cannot-link aliases from the overrides.
 func ReducePeople(people People, matcher external.Matcher, blacklist Blacklist,
-	maxIdentities int) error {
-	peopleGraph := simple.NewUndirectedGraph()
-	for index, person := range people {
-		peopleGraph.AddNode(node{person, index})
-	}
+	maxIdentities int, options ReduceOptions) error {
+	return reducePeople(people, nil, matcher, blacklist, maxIdentities, options)
+}
 
-	unmatchedEmails := map[string]struct{}{}
-	var err error
-	if matcher != nil {
-		unmatchedEmails, err = addEdgesWithMatcher(people, peopleGraph, matcher)
+// reducePeople implements ReducePeople. previous are the IDs of the people from the previous
+// run, see ReducePeopleIncrementally.
+func reducePeople(people People, previous map[int64]struct{}, matcher external.Matcher,
+	blacklist Blacklist, maxIdentities int, options ReduceOptions) error {
+	mailmap, heuristics, overrides := options.Mailmap, options.Heuristics, options.Overrides
+	if heuristics == nil {
+		var err error
+		heuristics, err = NewHeuristics(DefaultHeuristics, HeuristicOptions{})
 		if err != nil {
 			return err
 		}
 	}
-
-	// Add edges by the same unpopular email
-	email2id := make(map[string]node)
-	for index, person := range people {
-		for _, email := range person.Emails {
-			if matcher != nil {
-				if _, unmatched := unmatchedEmails[email]; !unmatched {
-					// Do not process emails which were matched by an external matcher
-					continue
-				}
-			}
-			if blacklist.isPopularEmail(email) {
-				reporter.Increment("popular emails found")
-				continue
-			}
-			if val, ok := email2id[email]; ok {
-				err = setEdge(peopleGraph, val, peopleGraph.Node(index).(node))
-				if err != nil {
-					return err
-				}
-			} else {
-				email2id[email] = peopleGraph.Node(index).(node)
-			}
+	if overrides == nil {
+		overrides = &Overrides{}
+	}
+	graph := newMatchingGraph(people, blacklist, maxIdentities)
+	graph.previous = previous
+	graph.setCannotLink(people, overrides.CannotLink)
+	if matcher != nil {
+		graph.heuristic = "external"
+		if err := addEdgesWithMatcher(people, graph, matcher); err != nil {
+			return err
 		}
 	}
-	reporter.Commit("people matched by email", len(email2id))
 
-	// Add edges by the same unpopular name
-	name2id := make(map[string]map[string][]node)
-	// We need to sort keys because the algorithm is order dependent
-	keys := make([]int64, 0, len(people))
-	for k := range people {
-		keys = append(keys, k)
+	graph.heuristic = "overrides"
+	if err := addEdgesWithOverrides(people, graph, overrides); err != nil {
+		return err
 	}
-	Int64Slice(keys).Sort()
-	for _, index := range keys {
-		myNode := peopleGraph.Node(index).(node)
-		for _, name := range myNode.Value.NamesWithRepos {
-			if blacklist.isPopularName(name.String()) {
-				reporter.Increment("popular names found")
-				continue
-			}
-			for { // this for is to exit with break from the block when required
-				sameNameIDNodes, exists := name2id[name.String()]
-				if exists {
-					if sameNameAndExternalIDNodes, exists := sameNameIDNodes[myNode.Value.ExternalID]; exists {
-						for _, connectedNode := range sameNameAndExternalIDNodes {
-							if !passIdentitiesLimit(peopleGraph, maxIdentities, myNode, connectedNode) {
-								continue
-							}
-							err = setEdge(peopleGraph, connectedNode, myNode)
-							if err != nil {
-								return err
-							}
-						}
-						break
-					}
-				} else {
-					sameNameIDNodes = map[string][]node{}
-					name2id[name.String()] = sameNameIDNodes
-				}
-				sameNameIDNodes[myNode.Value.ExternalID] = append(sameNameIDNodes[myNode.Value.ExternalID], myNode)
-				break
-			}
-		}
+
+	graph.heuristic = "mailmap"
+	mailmapEntries, err := addEdgesWithMailmap(people, graph, mailmap)
+	if err != nil {
+		return err
 	}
 
-	// Merge names with only one found external id
-	for _, externalIDs := range name2id {
-		if len(externalIDs) == 2 { // one should be empty => merge them
-			toMerge := false
-			var connected []node
-			for externalID, nodes := range externalIDs {
-				if externalID == "" {
-					toMerge = true
-				}
-				connected = append(connected, nodes...)
-			}
-			if toMerge {
-				for x, edgeX := range connected {
-					for _, edgeY := range connected[x+1:] {
-						if !passIdentitiesLimit(peopleGraph, maxIdentities, edgeX, edgeY) {
-							continue
-						}
-						err = setEdge(peopleGraph, edgeX, edgeY)
-						// err can occur here and it is fine.
-					}
-				}
-			}
+	for _, heuristic := range heuristics {
+		graph.heuristic = heuristic.Name()
+		if err := heuristic.AddEdges(people, graph); err != nil {
+			return fmt.Errorf("heuristic %s failed: %v", heuristic.Name(), err)
 		}
 	}
 
-	reporter.Commit("people matched by name", len(name2id))
+	graph.removeWeakEdges(options.Components.MinConfidence)
+	graph.splitComponents(options.Components.Split)
 
 	var componentsSize []float64
-	for _, component := range topo.ConnectedComponents(peopleGraph) {
+	var confidences []float64
+	mergedIDs := map[int64]int64{}
+	for _, component := range connectedComponents(graph.graph) {
 		var toMerge []int64
 		for _, node := range component {
 			toMerge = append(toMerge, node.ID())
 		}
 		componentsSize = append(componentsSize, float64(len(toMerge)))
-		_, err := people.Merge(toMerge...)
+		confidence := componentConfidence(graph.graph, component)
+		confidences = append(confidences, confidence)
+		id, err := people.Merge(toMerge...)
+		if err != nil {
+			return err
+		}
+		people[id].Confidence = confidence
+		for _, merged := range toMerge {
+			mergedIDs[merged] = id
+		}
+		err = setMailmapPrimaryValues(people[id], toMerge, mailmapEntries, mailmap)
 		if err != nil {
 			return err
 		}
 	}
+	setPinnedPrimaryValues(people, overrides.Primaries)
+	for _, edge := range graph.edges {
+		person := people[mergedIDs[edge.Node1.ID]]
+		person.Provenance = append(person.Provenance, edge)
+		reporter.Increment("edges added by " + edge.Heuristic)
+	}
 	mean, std := stat.MeanStdDev(componentsSize, nil)
 	if mean != mean {
 		mean = 0
@@ -230,25 +251,16 @@ func ReducePeople(people People, matcher external.Matcher, blacklist Blacklist,
 	reporter.Commit("connected component size mean", mean)
 	reporter.Commit("connected component size std", std)
 	reporter.Commit("connected component size max", floats.Max(componentsSize))
+	reporter.Commit("person confidence mean", stat.Mean(confidences, nil))
+	reporter.Commit("person confidence min", floats.Min(confidences))
 	reporter.Commit("people after reduce", len(people))
 
 	return nil
 }
 
-func passIdentitiesLimit(graph *simple.UndirectedGraph, maxIdentities int, node1, node2 node) bool {
-	n1Emails, n1Names := componentUniqueEmailsAndNames(graph, node1)
-	n2Emails, n2Names := componentUniqueEmailsAndNames(graph, node2)
-	if n1Emails+n1Names >= maxIdentities || n2Names+n2Emails >= maxIdentities {
-		logrus.Debugf(
-			"above the identities limit: %s (%d emails, %d names) and %s (%d emails, %d names)",
-			node1.Value.String(), n1Emails, n1Names, node2.Value.String(), n2Emails, n2Names)
-		return false
-	}
-	return true
-}
-
-// setEdge propagates ExternalID when you connect two components
-func setEdge(graph *simple.UndirectedGraph, node1, node2 node) error {
+// setEdge propagates ExternalID when you connect two components. The weight of the edge is
+// the confidence which is combined with the existing edge's confidence, if any.
+func (g *MatchingGraph) setEdge(node1, node2 node, confidence float64) error {
 	externalID1 := node1.Value.ExternalID
 	externalID2 := node2.Value.ExternalID
 	if externalID1 != "" && externalID2 != "" && externalID1 != externalID2 {
@@ -266,42 +278,25 @@ func setEdge(graph *simple.UndirectedGraph, node1, node2 node) error {
 		nodeToFix = node2
 	}
 	if newExternalID != "" {
-		var w traverse.DepthFirst
-		w.Walk(graph, nodeToFix, func(sn simplegraph.Node) bool {
-			n := sn.(node)
+		for _, n := range g.components.get(nodeToFix.ID()).members {
 			if n.Value.ExternalID != "" && n.Value.ExternalID != newExternalID {
 				panic(fmt.Errorf(
 					"cannot set edge between components with different ExternalIDs: |%s| |%s|",
 					newExternalID, n.Value.ExternalID))
 			}
 			n.Value.ExternalID = newExternalID
-			return false
-		})
+		}
 	}
 
-	graph.SetEdge(graph.NewEdge(node1, node2))
+	if edge := g.graph.WeightedEdge(node1.ID(), node2.ID()); edge != nil {
+		confidence = combineConfidence(edge.Weight(), confidence)
+	}
+	g.graph.SetWeightedEdge(g.graph.NewWeightedEdge(node1, node2, confidence))
+	g.components.join(node1.ID(), node2.ID())
 	reporter.Increment("graph edges")
 	return nil
 }
 
-// componentUniqueEmailsAndNames calculates the number of unique emails and names in the component
-// with n node inside
-func componentUniqueEmailsAndNames(graph *simple.UndirectedGraph, n simplegraph.Node) (int, int) {
-	emails := map[string]struct{}{}
-	names := map[string]struct{}{}
-	var w traverse.DepthFirst
-	w.Walk(graph, n, func(sn simplegraph.Node) bool {
-		for _, email := range sn.(node).Value.Emails {
-			emails[email] = struct{}{}
-		}
-		for _, name := range sn.(node).Value.NamesWithRepos {
-			names[name.String()] = struct{}{}
-		}
-		return false
-	})
-	return len(emails), len(names)
-}
-
 func setPrimaryValue(people People, freqs map[string]*Frequency, getter func(*Person) []string,
 	setter func(*Person, string), minRecentCount int) {
 	for _, p := range people {
@@ -321,10 +316,12 @@ func setPrimaryValue(people People, freqs map[string]*Frequency, getter func(*Pe
 					totalMaxFreq = freq.Total
 					totalPrimaryValue = value
 				}
-			} else {
-				logrus.Panicf("freqs does not contain %s key", value)
 			}
 		}
+		if totalPrimaryValue == "" {
+			// none of the values were seen in the commits, e.g. the aliases of the previous people
+			continue
+		}
 		if sumRecentCount >= minRecentCount {
 			setter(p, recentPrimaryValue)
 		} else {
@@ -336,7 +333,8 @@ func setPrimaryValue(people People, freqs map[string]*Frequency, getter func(*Pe
 // SetPrimaryValues sets people primary name and email to the most frequent name and email of
 // the person's identity. Stats for the fixed recent period of time are used if there are at least
 // minRecentCount commits made by the person's identity in that period. Otherwise the stats
-// for all the time are used.
+// for all the time are used. The values which are already set, e.g. from the mailmap or from
+// the previous run, are kept. The values which are missing in the stats are ignored.
 func SetPrimaryValues(people People, nameFreqs, emailFreqs map[string]*Frequency,
 	minRecentCount int) {
 	setPrimaryValue(people, nameFreqs, func(p *Person) []string {
@@ -345,7 +343,15 @@ func SetPrimaryValues(people People, nameFreqs, emailFreqs map[string]*Frequency
 			names[i] = n.Name
 		}
 		return names
-	}, func(p *Person, name string) { p.PrimaryName = name }, minRecentCount)
+	}, func(p *Person, name string) {
+		if p.PrimaryName == "" {
+			p.PrimaryName = name
+		}
+	}, minRecentCount)
 	setPrimaryValue(people, emailFreqs, func(p *Person) []string { return p.Emails },
-		func(p *Person, email string) { p.PrimaryEmail = email }, minRecentCount)
+		func(p *Person, email string) {
+			if p.PrimaryEmail == "" {
+				p.PrimaryEmail = email
+			}
+		}, minRecentCount)
 }
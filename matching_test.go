@@ -3,17 +3,29 @@ package idmatch
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
-	"gonum.org/v1/gonum/graph/simple"
 
 	"github.com/src-d/identity-matching/external"
+	"github.com/src-d/identity-matching/reporter"
 )
 
 var githubTestToken = os.Getenv("GITHUB_TEST_TOKEN")
 
+// withoutMatchDetails clears the provenance and the confidence of the merged people so that
+// they can be compared with the expected people.
+func withoutMatchDetails(people People) People {
+	for _, person := range people {
+		person.Provenance = nil
+		person.Confidence = 0
+	}
+	return people
+}
+
 func TestReducePeople(t *testing.T) {
 	commit := &Commit{"xxx", "repo"}
 	var people = People{
@@ -41,9 +53,9 @@ func TestReducePeople(t *testing.T) {
 
 	blacklist := newTestBlacklist(t)
 
-	err := ReducePeople(people, nil, blacklist, 100)
+	err := ReducePeople(people, nil, blacklist, 100, ReduceOptions{})
 	require.Equal(t, err, nil)
-	require.Equal(t, people, reducedPeople)
+	require.Equal(t, reducedPeople, withoutMatchDetails(people))
 }
 
 func TestReducePeopleMaxIdentities(t *testing.T) {
@@ -78,9 +90,9 @@ func TestReducePeopleMaxIdentities(t *testing.T) {
 
 	blacklist := newTestBlacklist(t)
 
-	err := ReducePeople(people, nil, blacklist, 4)
+	err := ReducePeople(people, nil, blacklist, 4, ReduceOptions{})
 	require.Equal(t, err, nil)
-	require.Equal(t, reducedPeople, people)
+	require.Equal(t, reducedPeople, withoutMatchDetails(people))
 }
 
 func printTestSkippedNoToken() {
@@ -119,10 +131,10 @@ func TestReducePeopleExternalMatching(t *testing.T) {
 	blacklist := newTestBlacklist(t)
 	matcher, _ := external.NewGitHubMatcher("", githubTestToken)
 
-	err := ReducePeople(people, matcher, blacklist, 100)
+	err := ReducePeople(people, matcher, blacklist, 100, ReduceOptions{})
 
 	require.Equal(t, err, nil)
-	require.Equal(t, people, reducedPeople)
+	require.Equal(t, reducedPeople, withoutMatchDetails(people))
 }
 
 func TestReducePeopleBothMatching(t *testing.T) {
@@ -184,10 +196,10 @@ func TestReducePeopleBothMatching(t *testing.T) {
 	blacklist := newTestBlacklist(t)
 	matcher, _ := external.NewGitHubMatcher("", githubTestToken)
 
-	err := ReducePeople(people, matcher, blacklist, 100)
+	err := ReducePeople(people, matcher, blacklist, 100, ReduceOptions{})
 
 	require.Equal(t, err, nil)
-	require.Equal(t, people, reducedPeople)
+	require.Equal(t, reducedPeople, withoutMatchDetails(people))
 }
 
 func TestReducePeopleBothMatchingDifferentExternalIdsNoMerge(t *testing.T) {
@@ -254,10 +266,10 @@ func TestReducePeopleBothMatchingDifferentExternalIdsNoMerge(t *testing.T) {
 	blacklist := newTestBlacklist(t)
 	matcher, _ := external.NewGitHubMatcher("", githubTestToken)
 
-	err := ReducePeople(people, matcher, blacklist, 100)
+	err := ReducePeople(people, matcher, blacklist, 100, ReduceOptions{})
 
 	require.Equal(t, err, nil)
-	require.Equal(t, people, reducedPeople)
+	require.Equal(t, reducedPeople, withoutMatchDetails(people))
 }
 
 type TestMatcher struct {
@@ -309,9 +321,9 @@ func TestReducePeopleSameNameDifferentExternalIds(t *testing.T) {
 
 	blacklist := newTestBlacklist(t)
 
-	err := ReducePeople(people, TestMatcher{}, blacklist, 100)
+	err := ReducePeople(people, TestMatcher{}, blacklist, 100, ReduceOptions{})
 	require.Equal(t, err, nil)
-	require.Equal(t, people, reducedPeople)
+	require.Equal(t, reducedPeople, withoutMatchDetails(people))
 }
 
 func TestSetPrimaryValue(t *testing.T) {
@@ -414,13 +426,161 @@ func TestAddEdgesWithMatcherCommits(t *testing.T) {
 			Repo: "git://github.com/src-d/hercules.git",
 		}}
 	matcher, _ := external.NewGitHubMatcher("", githubTestToken)
-	peopleGraph := simple.NewUndirectedGraph()
-	for index, person := range people {
-		peopleGraph.AddNode(node{person, index})
-	}
-	unprocessedEmails, err := addEdgesWithMatcher(people, peopleGraph, matcher)
+	graph := newMatchingGraph(people, newTestBlacklist(t), 100)
+	err := addEdgesWithMatcher(people, graph, matcher)
 	req := require.New(t)
 	req.NoError(err)
-	req.Equal(0, len(unprocessedEmails))
+	req.Equal(0, len(graph.unmatchedEmails))
 	req.Equal("vmarkovtsev", people[1].ExternalID)
 }
+
+func TestReducePeopleMailmap(t *testing.T) {
+	var people = People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@google.com"}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"bobby", ""}}, Emails: []string{"bobby@gmail.com"}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"robert", ""}},
+			Emails: []string{"bobby@gmail.com"}},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice", ""}}, Emails: []string{"alice@localhost"}},
+		5: {ID: 5, NamesWithRepos: []NameWithRepo{{"alice", ""}}, Emails: []string{"popular@google.com"}},
+		6: {ID: 6, NamesWithRepos: []NameWithRepo{{"carol", ""}}, Emails: []string{"carol@google.com"}},
+	}
+	mailmap := Mailmap{
+		{ProperName: "Bob Smith", ProperEmail: "bob@google.com",
+			CommitName: "Bobby", CommitEmail: "bobby@gmail.com"},
+		{ProperName: "Alice", ProperEmail: "alice@localhost", CommitEmail: "popular@google.com"},
+		{ProperName: "Carol C.", CommitEmail: "Carol@google.com"},
+		{ProperName: "Nobody", CommitEmail: "nobody@google.com"},
+	}
+
+	var reducedPeople = People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}, {"bobby", ""}, {"robert", ""}},
+			Emails: []string{"bob@google.com", "bobby@gmail.com"}, PrimaryName: "bob smith",
+			PrimaryEmail: "bob@google.com",
+			RawNames:     map[string][]string{"bob smith": {"Bob Smith"}}},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice", ""}},
+			Emails: []string{"alice@localhost", "popular@google.com"}, PrimaryName: "alice",
+			PrimaryEmail: "alice@localhost", RawNames: map[string][]string{"alice": {"Alice"}}},
+		6: {ID: 6, NamesWithRepos: []NameWithRepo{{"carol", ""}}, Emails: []string{"carol@google.com"},
+			PrimaryName: "carol c.", RawNames: map[string][]string{"carol c.": {"Carol C."}}},
+	}
+
+	blacklist := newTestBlacklist(t)
+
+	// the identities limit does not apply to the mailmap edges
+	err := ReducePeople(people, nil, blacklist, 1, ReduceOptions{Mailmap: mailmap})
+	require.NoError(t, err)
+	require.Equal(t, []EdgeProvenance{
+		{Heuristic: "mailmap", Reason: "mailmap",
+			Value: "Bob Smith <bob@google.com> Bobby <bobby@gmail.com>", Confidence: 1,
+			Node1: EdgeNode{ID: 2, Names: []NameWithRepo{{"bobby", ""}}, Emails: []string{"bobby@gmail.com"},
+				Repos: []string{}},
+			Node2: EdgeNode{ID: 1, Names: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@google.com"},
+				Repos: []string{}}},
+		{Heuristic: "email", Reason: "same email", Value: "bobby@gmail.com", Confidence: 1,
+			Node1: EdgeNode{ID: 2, Names: []NameWithRepo{{"bobby", ""}}, Emails: []string{"bobby@gmail.com"},
+				Repos: []string{}},
+			Node2: EdgeNode{ID: 3, Names: []NameWithRepo{{"robert", ""}}, Emails: []string{"bobby@gmail.com"},
+				Repos: []string{}}},
+	}, people[1].Provenance)
+	require.Len(t, people[4].Provenance, 1)
+	require.Empty(t, people[6].Provenance)
+	require.Equal(t, 1.0, people[1].Confidence)
+	require.Equal(t, 1.0, people[6].Confidence)
+	require.Equal(t, reducedPeople, withoutMatchDetails(people))
+
+	SetPrimaryValues(people, map[string]*Frequency{
+		"bob": {1, 1}, "bobby": {1, 1}, "robert": {5, 5}, "alice": {1, 1}, "carol": {1, 1},
+	}, map[string]*Frequency{
+		"bob@google.com": {1, 1}, "bobby@gmail.com": {5, 5}, "alice@localhost": {1, 1},
+		"popular@google.com": {5, 5}, "carol@google.com": {1, 1},
+	}, 1)
+	require.Equal(t, "bob smith", people[1].PrimaryName)
+	require.Equal(t, "bob@google.com", people[1].PrimaryEmail)
+	require.Equal(t, "alice", people[4].PrimaryName)
+	require.Equal(t, "alice@localhost", people[4].PrimaryEmail)
+	require.Equal(t, "carol c.", people[6].PrimaryName)
+	require.Equal(t, "carol@google.com", people[6].PrimaryEmail)
+}
+
+func TestReducePeopleMailmapCannotLink(t *testing.T) {
+	req := require.New(t)
+	people := People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@google.com"}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"bobby", ""}}, Emails: []string{"bobby@gmail.com"}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"robert", ""}}, Emails: []string{"bob@corp.com"}},
+	}
+	mailmap := Mailmap{
+		{ProperName: "Bob Smith", ProperEmail: "bob@google.com", CommitEmail: "bobby@gmail.com"},
+		{ProperName: "Robert", ProperEmail: "bob@google.com", CommitEmail: "bob@corp.com"},
+	}
+	overrides := &Overrides{
+		CannotLink: [][2]Alias{{{Value: "bob@google.com"}, {Value: "bobby@gmail.com"}}}}
+	req.NoError(ReducePeople(people, nil, newTestBlacklist(t), 100, ReduceOptions{
+		Mailmap: mailmap, Overrides: overrides}))
+	value, _ := reporter.Get("mailmap edges")
+	req.Equal(1, value)
+	// the first entry applies only to bobby who is not joined with bob
+	req.Equal(People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}, {"robert", ""}},
+			Emails: []string{"bob@corp.com", "bob@google.com"}, PrimaryName: "robert",
+			PrimaryEmail: "bob@google.com", RawNames: map[string][]string{"robert": {"Robert"}}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"bobby", ""}}, Emails: []string{"bobby@gmail.com"},
+			PrimaryName: "bob smith", PrimaryEmail: "bob@google.com",
+			RawNames: map[string][]string{"bob smith": {"Bob Smith"}}},
+	}, withoutMatchDetails(people))
+}
+
+func newDeterminismTestPeople() People {
+	people := newTeamEmailPeople()
+	for _, person := range []*Person{
+		{NamesWithRepos: []NameWithRepo{{"Bob", ""}}, Emails: []string{"Bob@google.com"}},
+		{NamesWithRepos: []NameWithRepo{{"Bob", ""}}, Emails: []string{"Bob2@google.com"}},
+		{NamesWithRepos: []NameWithRepo{{"Bob", ""}}, Emails: []string{"bob@gmail.com"}},
+		{NamesWithRepos: []NameWithRepo{{"Alice", ""}}, Emails: []string{"alice@google.com"}},
+		{NamesWithRepos: []NameWithRepo{{"john smith", ""}}, Emails: []string{"john@google.com"}},
+		{NamesWithRepos: []NameWithRepo{{"j. smith", ""}}, Emails: []string{"jsmith@corp.com"}},
+		{NamesWithRepos: []NameWithRepo{{"smith john", "repo"}},
+			Emails: []string{"john.smith@corp.com"}},
+		{NamesWithRepos: []NameWithRepo{{"john smith", ""}}, Emails: []string{"john@gmail.com"}},
+	} {
+		person.ID = int64(len(people) + 1)
+		people[person.ID] = person
+	}
+	return people
+}
+
+func TestReducePeopleDeterministic(t *testing.T) {
+	req := require.New(t)
+	blacklist := newTestBlacklist(t)
+	heuristics, err := NewHeuristics(
+		[]string{"email", "name", "single-external-id", "fuzzy-name", "email-name"},
+		HeuristicOptions{NameSimilarity: 0.8,
+			NameFreqs: map[string]*Frequency{"john smith": {Total: 3}, "bob": {Total: 3}}})
+	req.NoError(err)
+	reduce := func(matcher external.Matcher) People {
+		people := newDeterminismTestPeople()
+		req.NoError(ReducePeople(people, matcher, blacklist, 100, ReduceOptions{
+			Heuristics: heuristics,
+			Components: ComponentOptions{Split: SplitOptions{MinSize: 10, MinModularity: 0.1}}}))
+		return people
+	}
+	expected := reduce(TestMatcher{})
+	req.True(len(expected) < len(newDeterminismTestPeople()))
+	for i := 0; i < 50; i++ {
+		req.Equal(expected, reduce(TestMatcher{}))
+	}
+	// the concurrent queries finish in random order
+	for i := 0; i < 10; i++ {
+		req.Equal(expected, reduce(external.WithWorkers(slowTestMatcher{}, 4)))
+	}
+}
+
+// slowTestMatcher is TestMatcher which answers after a random delay.
+type slowTestMatcher struct {
+	TestMatcher
+}
+
+func (m slowTestMatcher) MatchByEmail(ctx context.Context, email string) (string, error) {
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	return m.TestMatcher.MatchByEmail(ctx, email)
+}
@@ -0,0 +1,237 @@
+package idmatch
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// Overrides are the manual corrections of the matching which every run respects.
+type Overrides struct {
+	// MustLink are the pairs of aliases which belong to the same person.
+	MustLink [][2]Alias
+	// CannotLink are the pairs of aliases which belong to different people. No edge may join
+	// them, even through other identities.
+	CannotLink [][2]Alias
+	// Primaries are the pinned primary names and emails of the people with the aliases.
+	Primaries []PinnedPrimary
+}
+
+// PinnedPrimary sets the primary name and email of the person with the alias. Name and Email
+// may be empty, in which case the corresponding value is chosen as usual.
+type PinnedPrimary struct {
+	Alias Alias
+	Name  string
+	Email string
+}
+
+// ParseOverrides reads the overrides in CSV. Each line is one of:
+//
+//	must-link,<alias>,<alias>
+//	cannot-link,<alias>,<alias>
+//	primary,<alias>,<name>,<email>
+//
+// An alias is an email if it contains "@" and a name otherwise. The lines which start with "#"
+// are ignored.
+func ParseOverrides(r io.Reader) (*Overrides, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	overrides := &Overrides{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line := strings.Join(record, ",")
+		switch record[0] {
+		case "must-link", "cannot-link":
+			if len(record) != 3 {
+				return nil, fmt.Errorf("%s: %s requires two aliases", line, record[0])
+			}
+			var pair [2]Alias
+			for i := range pair {
+				if pair[i], err = cleanAlias(Alias{Value: record[i+1]}); err != nil {
+					return nil, fmt.Errorf("%s: %v", line, err)
+				}
+			}
+			if pair[0].Value == "" || pair[1].Value == "" {
+				return nil, fmt.Errorf("%s: empty alias", line)
+			}
+			if record[0] == "must-link" {
+				overrides.MustLink = append(overrides.MustLink, pair)
+			} else {
+				overrides.CannotLink = append(overrides.CannotLink, pair)
+			}
+		case "primary":
+			if len(record) != 4 {
+				return nil, fmt.Errorf("%s: primary requires the alias, the name and the email",
+					line)
+			}
+			alias, err := cleanAlias(Alias{Value: record[1]})
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", line, err)
+			}
+			pinned := PinnedPrimary{Alias: alias}
+			if pinned.Name, err = cleanName(record[2]); err != nil {
+				return nil, fmt.Errorf("%s: %v", line, err)
+			}
+			if pinned.Email, err = cleanEmail(record[3]); err != nil {
+				return nil, fmt.Errorf("%s: %v", line, err)
+			}
+			overrides.Primaries = append(overrides.Primaries, pinned)
+		default:
+			return nil, fmt.Errorf("%s: unknown override: %s", line, record[0])
+		}
+	}
+	return overrides, nil
+}
+
+// ReadOverrides loads the overrides from the CSV file at path.
+func ReadOverrides(path string) (overrides *Overrides, err error) {
+	var file *os.File
+	file, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		errClose := file.Close()
+		if err == nil {
+			err = errClose
+		}
+	}()
+	return ParseOverrides(file)
+}
+
+// matches returns true if the person has the alias.
+func (a Alias) matches(person *Person) bool {
+	if a.isEmail() {
+		for _, email := range person.Emails {
+			if email == a.Value {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range person.NamesWithRepos {
+		if name.Name == a.Value && (a.Repo == "" || name.Repo == a.Repo) {
+			return true
+		}
+	}
+	return false
+}
+
+// findAliasIDs returns the sorted IDs of the people with the alias.
+func findAliasIDs(people People, alias Alias) []int64 {
+	var ids []int64
+	people.ForEach(func(id int64, person *Person) bool {
+		if alias.matches(person) {
+			ids = append(ids, id)
+		}
+		return false
+	})
+	return ids
+}
+
+// cannotLinkSide is one of the aliases of a cannot-link constraint.
+type cannotLinkSide struct {
+	constraint int
+	side       int
+}
+
+// setCannotLink marks the people with the cannot-link aliases in the graph.
+func (g *MatchingGraph) setCannotLink(people People, constraints [][2]Alias) {
+	g.cannotLink = map[int64][]cannotLinkSide{}
+	for i, pair := range constraints {
+		for side, alias := range pair {
+			for _, id := range findAliasIDs(people, alias) {
+				g.cannotLink[id] = append(g.cannotLink[id], cannotLinkSide{i, side})
+				g.components.get(id).cannotLink[cannotLinkSide{i, side}] = struct{}{}
+			}
+		}
+		for _, id := range findAliasIDs(people, pair[0]) {
+			if pair[1].matches(people[id]) {
+				logrus.Warnf("cannot-link %s and %s is violated by the identity %s",
+					pair[0], pair[1], people[id].String())
+			}
+		}
+	}
+}
+
+// violatesCannotLink returns true if connecting the two people joins the cannot-link aliases
+// in the same component.
+func (g *MatchingGraph) violatesCannotLink(person1, person2 int64) bool {
+	if len(g.cannotLink) == 0 {
+		return false
+	}
+	c1, c2 := g.components.get(person1), g.components.get(person2)
+	if c1 == c2 {
+		return false
+	}
+	for side := range c1.cannotLink {
+		if _, exists := c2.cannotLink[cannotLinkSide{side.constraint, 1 - side.side}]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// addEdgesWithOverrides connects the must-link aliases. Those edges are forced like the mailmap
+// edges, but they must not violate the cannot-link constraints.
+func addEdgesWithOverrides(people People, graph *MatchingGraph, overrides *Overrides) error {
+	edges := 0
+	for _, pair := range overrides.MustLink {
+		ids1, ids2 := findAliasIDs(people, pair[0]), findAliasIDs(people, pair[1])
+		if len(ids1) == 0 || len(ids2) == 0 {
+			logrus.Warnf("ignored must-link %s and %s: the aliases were not found", pair[0], pair[1])
+			continue
+		}
+		ids := append(ids1, ids2...)
+		value := pair[0].Value + " = " + pair[1].Value
+		for _, id := range ids[1:] {
+			if id == ids[0] || graph.HasEdge(ids[0], id) {
+				continue
+			}
+			if graph.violatesCannotLink(ids[0], id) {
+				return fmt.Errorf("must-link %s and %s contradicts the cannot-link overrides",
+					pair[0], pair[1])
+			}
+			if err := graph.AddEdge(Edge{ids[0], id, "must-link", value, 1}); err != nil {
+				return fmt.Errorf("must-link %s and %s: %v", pair[0], pair[1], err)
+			}
+			edges++
+		}
+	}
+	reporter.Commit("must-link edges", edges)
+	return nil
+}
+
+// setPinnedPrimaryValues sets the pinned primary names and emails of the merged people.
+func setPinnedPrimaryValues(people People, primaries []PinnedPrimary) {
+	for _, pinned := range primaries {
+		ids := findAliasIDs(people, pinned.Alias)
+		if len(ids) == 0 {
+			logrus.Warnf("ignored the pinned primary values of %s: the alias was not found",
+				pinned.Alias)
+			continue
+		}
+		for _, id := range ids {
+			if pinned.Name != "" {
+				people[id].PrimaryName = pinned.Name
+			}
+			if pinned.Email != "" {
+				people[id].PrimaryEmail = pinned.Email
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package idmatch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOverrides(t *testing.T) {
+	req := require.New(t)
+	overrides, err := ParseOverrides(strings.NewReader(`# two different people
+cannot-link,DGarcia@a.com,dg@b.com
+must-link,Alice@a.com, Ally
+primary,ally,Alice Smith,
+`))
+	req.NoError(err)
+	req.Equal(&Overrides{
+		MustLink:   [][2]Alias{{{Value: "alice@a.com"}, {Value: "ally"}}},
+		CannotLink: [][2]Alias{{{Value: "dgarcia@a.com"}, {Value: "dg@b.com"}}},
+		Primaries:  []PinnedPrimary{{Alias: Alias{Value: "ally"}, Name: "alice smith"}},
+	}, overrides)
+
+	for _, text := range []string{
+		"merge,a@a.com,b@b.com",
+		"must-link,a@a.com",
+		"cannot-link,a@a.com,",
+		"primary,a@a.com,A",
+	} {
+		_, err = ParseOverrides(strings.NewReader(text))
+		req.Error(err, text)
+	}
+}
+
+func TestReducePeopleOverrides(t *testing.T) {
+	req := require.New(t)
+	newPeople := func() People {
+		return People{
+			1: {ID: 1, NamesWithRepos: []NameWithRepo{{"david garcia", ""}},
+				Emails: []string{"dgarcia@a.com"}},
+			2: {ID: 2, NamesWithRepos: []NameWithRepo{{"dave", ""}}, Emails: []string{"dg@b.com"}},
+			3: {ID: 3, NamesWithRepos: []NameWithRepo{{"david garcia", ""}},
+				Emails: []string{"dg@b.com"}},
+			4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice", ""}}, Emails: []string{"alice@a.com"}},
+			5: {ID: 5, NamesWithRepos: []NameWithRepo{{"ally", ""}}, Emails: []string{"ally@b.com"}},
+		}
+	}
+	blacklist := newTestBlacklist(t)
+
+	people := newPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{}))
+	req.Len(people, 3)
+
+	overrides := &Overrides{
+		MustLink:   [][2]Alias{{{Value: "alice@a.com"}, {Value: "ally"}}},
+		CannotLink: [][2]Alias{{{Value: "dgarcia@a.com"}, {Value: "dg@b.com"}}},
+		Primaries: []PinnedPrimary{{Alias: Alias{Value: "ally"}, Name: "alice smith"},
+			{Alias: Alias{Value: "dave"}, Email: "dg@b.com"}},
+	}
+	people = newPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{Overrides: overrides}))
+	// 3 is connected to 2 by email and thus cannot be connected to 1 by name
+	req.Equal(People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"david garcia", ""}},
+			Emails: []string{"dgarcia@a.com"}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"dave", ""}, {"david garcia", ""}},
+			Emails: []string{"dg@b.com"}, PrimaryEmail: "dg@b.com"},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice", ""}, {"ally", ""}},
+			Emails: []string{"alice@a.com", "ally@b.com"}, PrimaryName: "alice smith"},
+	}, withoutMatchDetails(people))
+
+	people = newPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{Overrides: overrides}))
+	req.Len(people[4].Provenance, 1)
+	req.Equal("overrides", people[4].Provenance[0].Heuristic)
+	req.Equal("must-link", people[4].Provenance[0].Reason)
+
+	overrides.MustLink = append(overrides.MustLink, [2]Alias{{Value: "david garcia"}, {Value: "dave"}})
+	people = newPeople()
+	req.EqualError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{Overrides: overrides}),
+		"must-link david garcia and dave contradicts the cannot-link overrides")
+}
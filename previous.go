@@ -0,0 +1,112 @@
+package idmatch
+
+import (
+	"github.com/src-d/identity-matching/external"
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// copyPerson returns the copy of the person which can be merged without changing the original.
+func copyPerson(person *Person) *Person {
+	copied := *person
+	copied.Emails = append([]string{}, person.Emails...)
+	copied.NamesWithRepos = append([]NameWithRepo{}, person.NamesWithRepos...)
+	copied.Repos = append([]string{}, person.Repos...)
+	copied.Provenance = append([]EdgeProvenance{}, person.Provenance...)
+	copied.EmailRoles = mergeRoles(nil, person.EmailRoles)
+	copied.NameRoles = mergeNameRoles(nil, person.NameRoles)
+	copied.RawNames = mergeRoles(nil, person.RawNames)
+	return &copied
+}
+
+// combineWithPrevious returns the copies of the previous people together with the new
+// identities. The identities whose emails and names all belong to the same previous person
+// are added to that person right away. The new identities get the IDs which are greater than
+// any previous ID. The previous people keep their primary names and emails, because some of
+// their aliases may be absent from the fresh stats. It also returns the IDs of the previous people.
+func combineWithPrevious(people, previous People) (People, map[int64]struct{}) {
+	combined := People{}
+	previousIDs := map[int64]struct{}{}
+	email2id := map[string]int64{}
+	name2ids := map[NameWithRepo][]int64{}
+	var maxID int64
+	previous.ForEach(func(id int64, person *Person) bool {
+		combined[id] = copyPerson(person)
+		previousIDs[id] = struct{}{}
+		for _, email := range person.Emails {
+			email2id[email] = id
+		}
+		for _, name := range person.NamesWithRepos {
+			name2ids[name] = append(name2ids[name], id)
+		}
+		if id > maxID {
+			maxID = id
+		}
+		for _, edge := range person.Provenance {
+			for _, nodeID := range []int64{edge.Node1.ID, edge.Node2.ID} {
+				if nodeID > maxID {
+					maxID = nodeID
+				}
+			}
+		}
+		return false
+	})
+	// knownBy returns the ID of the previous person who has all the aliases of the identity
+	knownBy := func(person *Person) (int64, bool) {
+		var owner int64
+		for _, email := range person.Emails {
+			id, exists := email2id[email]
+			if !exists || (owner != 0 && id != owner) {
+				return 0, false
+			}
+			owner = id
+		}
+		for _, name := range person.NamesWithRepos {
+			found := false
+			for _, id := range name2ids[name] {
+				if id == owner {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return 0, false
+			}
+		}
+		return owner, owner != 0
+	}
+	people.ForEach(func(id int64, person *Person) bool {
+		if owner, known := knownBy(person); known {
+			target := combined[owner]
+			target.Repos = unique(append(target.Repos, person.Repos...))
+			target.EmailRoles = mergeRoles(target.EmailRoles, person.EmailRoles)
+			target.NameRoles = mergeNameRoles(target.NameRoles, person.NameRoles)
+			target.RawNames = mergeRoles(target.RawNames, person.RawNames)
+			reporter.Increment("known identities")
+			return false
+		}
+		maxID++
+		copied := copyPerson(person)
+		copied.ID = maxID
+		combined[maxID] = copied
+		reporter.Increment("new identities")
+		return false
+	})
+	return combined, previousIDs
+}
+
+// ReducePeopleIncrementally merges the identities returned by FindPeople into the people from
+// the previous run, e.g. loaded with ReadFromParquet, instead of reducing all the identities
+// from scratch. The identities which the previous people already have are merged right away.
+// The rest are matched against the previous people and against each other the same way as
+// ReducePeople does, except that the heuristics never connect two previous people and
+// the external matcher only looks up the emails which the previous people do not have.
+// The previous people are not changed. It returns the reduced people.
+func ReducePeopleIncrementally(people, previous People, matcher external.Matcher,
+	blacklist Blacklist, maxIdentities int, options ReduceOptions) (People, error) {
+	combined, previousIDs := combineWithPrevious(people, previous)
+	err := reducePeople(combined, previousIDs, matcher, blacklist, maxIdentities, options)
+	if err != nil {
+		return nil, err
+	}
+	return combined, nil
+}
@@ -0,0 +1,102 @@
+package idmatch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/identity-matching/external"
+)
+
+// recordingMatcher finds nothing and remembers the requested emails.
+type recordingMatcher struct {
+	emails *[]string
+}
+
+func (m recordingMatcher) MatchByEmail(ctx context.Context, email string) (string, error) {
+	*m.emails = append(*m.emails, email)
+	return "", external.ErrNoMatches
+}
+
+func (m recordingMatcher) SupportsMatchingByCommit() bool {
+	return false
+}
+
+func (m recordingMatcher) MatchByCommit(ctx context.Context, email, repo, commit string) (
+	string, error) {
+	return "", external.ErrNoMatches
+}
+
+func (m recordingMatcher) OnIdle() error {
+	return nil
+}
+
+func TestReducePeopleIncrementally(t *testing.T) {
+	req := require.New(t)
+	previous := People{
+		10: {ID: 10, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@google.com"},
+			PrimaryName: "bob", PrimaryEmail: "bob@google.com"},
+		// the same name but a different person according to the previous run
+		11: {ID: 11, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@gmail.com"},
+			PrimaryName: "bob", PrimaryEmail: "bob@gmail.com"},
+	}
+	people := People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@google.com"},
+			Repos: []string{"repo1"}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"robert", ""}},
+			Emails: []string{"bob@google.com"}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"alice", ""}},
+			Emails: []string{"alice@google.com"}},
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"alice", ""}},
+			Emails: []string{"alice@gmail.com"}},
+	}
+	var emails []string
+	reduced, err := ReducePeopleIncrementally(people, previous, recordingMatcher{&emails},
+		newTestBlacklist(t), 100, ReduceOptions{})
+	req.NoError(err)
+	// the emails of the previous people are not looked up again
+	req.Equal([]string{"alice@google.com", "alice@gmail.com"}, emails)
+	req.Equal(People{
+		10: {ID: 10, NamesWithRepos: []NameWithRepo{{"bob", ""}, {"robert", ""}},
+			Emails: []string{"bob@google.com"}, Repos: []string{"repo1"},
+			PrimaryName: "bob", PrimaryEmail: "bob@google.com"},
+		11: {ID: 11, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@gmail.com"},
+			PrimaryName: "bob", PrimaryEmail: "bob@gmail.com"},
+		13: {ID: 13, NamesWithRepos: []NameWithRepo{{"alice", ""}},
+			Emails: []string{"alice@gmail.com", "alice@google.com"}},
+	}, withoutMatchDetails(reduced))
+	// the previous people are not changed
+	req.Equal([]string{"bob@google.com"}, previous[10].Emails)
+	req.Equal("bob", previous[10].PrimaryName)
+	req.Nil(previous[10].Repos)
+}
+
+func TestSetPrimaryValuesWithPrevious(t *testing.T) {
+	req := require.New(t)
+	previous := People{
+		10: {ID: 10, NamesWithRepos: []NameWithRepo{{"bob", ""}, {"robert", ""}},
+			Emails:      []string{"bob@google.com", "bob@old.com"},
+			PrimaryName: "robert", PrimaryEmail: "bob@old.com"},
+		// none of the aliases are in the new signatures
+		11: {ID: 11, NamesWithRepos: []NameWithRepo{{"carol", ""}},
+			Emails: []string{"carol@old.com"}, PrimaryName: "carol", PrimaryEmail: "carol@old.com"},
+	}
+	people := People{
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@google.com"}},
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"alice", ""}},
+			Emails: []string{"alice@google.com"}},
+	}
+	nameFreqs := map[string]*Frequency{"bob": {Total: 5}, "alice": {Total: 1}}
+	emailFreqs := map[string]*Frequency{"bob@google.com": {Total: 5}, "alice@google.com": {Total: 1}}
+	reduced, err := ReducePeopleIncrementally(people, previous, nil, newTestBlacklist(t), 100,
+		ReduceOptions{})
+	req.NoError(err)
+	req.NotPanics(func() { SetPrimaryValues(reduced, nameFreqs, emailFreqs, 1) })
+	req.Equal("robert", reduced[10].PrimaryName)
+	req.Equal("bob@old.com", reduced[10].PrimaryEmail)
+	req.Equal("carol", reduced[11].PrimaryName)
+	req.Equal("carol@old.com", reduced[11].PrimaryEmail)
+	req.Equal("alice", reduced[12].PrimaryName)
+	req.Equal("alice@google.com", reduced[12].PrimaryEmail)
+}
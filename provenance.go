@@ -0,0 +1,153 @@
+package idmatch
+
+import (
+	"strings"
+)
+
+// EdgeProvenance tells why two identities were merged into the same person.
+type EdgeProvenance struct {
+	// Heuristic is the name of the heuristic which connected the identities, "external" for
+	// the external matcher and "mailmap" for the mailmap.
+	Heuristic string
+	// Reason explains the connection, e.g. "same email".
+	Reason string
+	// Value is the shared value which caused the connection, e.g. the email.
+	Value string
+	// Confidence is the probability from 0 to 1 that the identities belong to the same person.
+	Confidence float64
+	// Node1 and Node2 are the connected identities.
+	Node1 EdgeNode
+	Node2 EdgeNode
+}
+
+// EdgeNode is an identity in the matching graph before the merge.
+type EdgeNode struct {
+	ID int64
+	// Names keep the repositories of the names which are scoped to them.
+	Names  []NameWithRepo
+	Emails []string
+	Repos  []string
+}
+
+func newEdgeNode(person *Person) EdgeNode {
+	return EdgeNode{
+		ID:     person.ID,
+		Names:  append([]NameWithRepo{}, person.NamesWithRepos...),
+		Emails: append([]string{}, person.Emails...),
+		Repos:  append([]string{}, person.Repos...),
+	}
+}
+
+// String describes the identity in the same format as Person.String().
+func (n EdgeNode) String() string {
+	names := make([]string, len(n.Names))
+	for i, name := range n.Names {
+		names[i] = name.String()
+	}
+	return strings.Join(names, "|") + "||" + strings.Join(n.Emails, "|")
+}
+
+// String describes the connection, e.g. "[bob||bob@google.com] -same email bob@google.com->
+// [robert||bob@google.com] (email)".
+func (p EdgeProvenance) String() string {
+	return "[" + p.Node1.String() + "] -" + p.Reason + " " + p.Value + "-> [" +
+		p.Node2.String() + "] (" + p.Heuristic + ")"
+}
+
+// reversed returns the same edge from Node2 to Node1.
+func (p EdgeProvenance) reversed() EdgeProvenance {
+	p.Node1, p.Node2 = p.Node2, p.Node1
+	return p
+}
+
+// parquetPersonEdge is the flattened EdgeProvenance of the person with the given ID.
+// The names, the emails and the repositories of the nodes are separated with "|". NameRepos
+// are the repositories of the names in the same order, empty for the names which are not scoped.
+type parquetPersonEdge struct {
+	ID        int64  `parquet:"name=id, type=INT_64"`
+	Heuristic string `parquet:"name=heuristic, type=UTF8"`
+	Reason    string `parquet:"name=reason, type=UTF8"`
+	Value     string `parquet:"name=value, type=UTF8"`
+	// Confidence is EdgeProvenance.Confidence.
+	Confidence float64 `parquet:"name=confidence, type=DOUBLE"`
+	Node1      int64   `parquet:"name=node1, type=INT_64"`
+	Names1     string  `parquet:"name=names1, type=UTF8"`
+	NameRepos1 string  `parquet:"name=name_repos1, type=UTF8"`
+	Emails1    string  `parquet:"name=emails1, type=UTF8"`
+	Repos1     string  `parquet:"name=repos1, type=UTF8"`
+	Node2      int64   `parquet:"name=node2, type=INT_64"`
+	Names2     string  `parquet:"name=names2, type=UTF8"`
+	NameRepos2 string  `parquet:"name=name_repos2, type=UTF8"`
+	Emails2    string  `parquet:"name=emails2, type=UTF8"`
+	Repos2     string  `parquet:"name=repos2, type=UTF8"`
+}
+
+// joinNames returns the "|"-separated names and the repositories of those names.
+func joinNames(names []NameWithRepo) (string, string) {
+	values := make([]string, len(names))
+	repos := make([]string, len(names))
+	for i, name := range names {
+		values[i] = name.Name
+		repos[i] = name.Repo
+	}
+	return strings.Join(values, "|"), strings.Join(repos, "|")
+}
+
+// splitNames reverses joinNames. The names are not scoped if the number of the repositories
+// does not match, e.g. the file was written before the repositories were stored.
+func splitNames(names, repos string) []NameWithRepo {
+	if names == "" {
+		return []NameWithRepo{}
+	}
+	values := strings.Split(names, "|")
+	valueRepos := strings.Split(repos, "|")
+	result := make([]NameWithRepo, len(values))
+	for i, value := range values {
+		result[i].Name = value
+		if len(valueRepos) == len(values) {
+			result[i].Repo = valueRepos[i]
+		}
+	}
+	return result
+}
+
+func newParquetPersonEdge(id int64, edge EdgeProvenance) parquetPersonEdge {
+	names1, nameRepos1 := joinNames(edge.Node1.Names)
+	names2, nameRepos2 := joinNames(edge.Node2.Names)
+	return parquetPersonEdge{
+		ID:         id,
+		Heuristic:  edge.Heuristic,
+		Reason:     edge.Reason,
+		Value:      edge.Value,
+		Confidence: edge.Confidence,
+		Node1:      edge.Node1.ID,
+		Names1:     names1,
+		NameRepos1: nameRepos1,
+		Emails1:    strings.Join(edge.Node1.Emails, "|"),
+		Repos1:     strings.Join(edge.Node1.Repos, "|"),
+		Node2:      edge.Node2.ID,
+		Names2:     names2,
+		NameRepos2: nameRepos2,
+		Emails2:    strings.Join(edge.Node2.Emails, "|"),
+		Repos2:     strings.Join(edge.Node2.Repos, "|"),
+	}
+}
+
+func (e parquetPersonEdge) provenance() EdgeProvenance {
+	split := func(s string) []string {
+		if s == "" {
+			return []string{}
+		}
+		return strings.Split(s, "|")
+	}
+	return EdgeProvenance{
+		Heuristic:  e.Heuristic,
+		Reason:     e.Reason,
+		Value:      e.Value,
+		Confidence: e.Confidence,
+		Node1: EdgeNode{ID: e.Node1, Names: splitNames(e.Names1, e.NameRepos1),
+			Emails: split(e.Emails1), Repos: split(e.Repos1)},
+		Node2: EdgeNode{ID: e.Node2, Names: splitNames(e.Names2, e.NameRepos2),
+			Emails: split(e.Emails2), Repos: split(e.Repos2)},
+	}
+}
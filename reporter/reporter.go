@@ -3,12 +3,16 @@ package reporter
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
 
 var report = map[string]interface{}{}
 
+// lock makes the report safe for concurrent use, e.g. by the external matchers.
+var lock sync.Mutex
+
 // Commit values to the report
 // To print values to stdout use Write function
 func Commit(key string, value interface{}) {
@@ -18,11 +22,15 @@ func Commit(key string, value interface{}) {
 	if f, casted := value.(float64); casted && f != f {
 		logrus.Panicf("Commit(\"%s\", %v)", key, f)
 	}
+	lock.Lock()
+	defer lock.Unlock()
 	report[key] = value
 }
 
 // Get value that was previously committed
 func Get(key string) (interface{}, bool) {
+	lock.Lock()
+	defer lock.Unlock()
 	val, ok := report[key]
 	return val, ok
 }
@@ -31,6 +39,8 @@ func Get(key string) (interface{}, bool) {
 // Works for int values only
 // Returns the new value of the counter.
 func Increment(key string) int {
+	lock.Lock()
+	defer lock.Unlock()
 	if _, exists := report[key]; !exists {
 		report[key] = 0
 	}
@@ -40,6 +50,8 @@ func Increment(key string) int {
 
 // Write function prints report to stdout and clear all values
 func Write() {
+	lock.Lock()
+	defer lock.Unlock()
 	if jsonString, err := json.Marshal(report); err == nil {
 		fmt.Println(string(jsonString))
 	} else {
@@ -49,5 +61,7 @@ func Write() {
 
 // Reset sets all the counter values to 0
 func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
 	report = map[string]interface{}{}
 }
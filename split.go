@@ -0,0 +1,152 @@
+package idmatch
+
+import (
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/graph/community"
+	"gonum.org/v1/gonum/graph/simple"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// ComponentOptions configure forming the people from the connected components of the matching
+// graph.
+type ComponentOptions struct {
+	// MinConfidence is the minimum confidence of the edges which join the components.
+	MinConfidence float64
+	// Split configures splitting the over-merged components.
+	Split SplitOptions
+}
+
+// SplitOptions configure splitting the over-merged components of the matching graph, e.g.
+// the people glued together by a shared team email.
+type SplitOptions struct {
+	// MinSize is the minimum number of identities in a component to try splitting it.
+	// Zero disables splitting.
+	MinSize int
+	// MinModularity is the minimum modularity of the detected communities which allows
+	// splitting the component. The higher, the more weakly connected the communities must be.
+	MinModularity float64
+}
+
+// DefaultSplitOptions are the split options of the command line. Splitting is disabled unless
+// MinSize is set, so the components are the same as without the split stage.
+var DefaultSplitOptions = SplitOptions{MinModularity: 0.3}
+
+// forcedHeuristics are the stages which add the edges that are never cut.
+var forcedHeuristics = map[string]struct{}{"external": {}, "overrides": {}, "mailmap": {}}
+
+func edgeKey(id1, id2 int64) [2]int64 {
+	if id1 > id2 {
+		id1, id2 = id2, id1
+	}
+	return [2]int64{id1, id2}
+}
+
+// splitComponents detects the communities in the components with at least options.MinSize
+// identities with the Louvain algorithm weighted by the edge confidences and cuts the edges
+// between them if the modularity is high enough. The communities connected by the forced edges
+// stay together. The external identities are reassigned in the split parts.
+func (g *MatchingGraph) splitComponents(options SplitOptions) {
+	if options.MinSize <= 0 {
+		return
+	}
+	forced := map[[2]int64]bool{}
+	for _, edge := range g.edges {
+		key := edgeKey(edge.Node1.ID, edge.Node2.ID)
+		if _, exists := forcedHeuristics[edge.Heuristic]; exists {
+			forced[key] = true
+		}
+	}
+	cut := map[[2]int64]struct{}{}
+	totalParts := 0
+	for _, component := range connectedComponents(g.graph) {
+		if len(component) < options.MinSize {
+			continue
+		}
+		subgraph := simple.NewWeightedUndirectedGraph(0, 0)
+		var keys [][2]int64
+		for _, n := range component {
+			subgraph.AddNode(simple.Node(n.ID()))
+		}
+		for _, n := range component {
+			neighbors := g.graph.From(n.ID())
+			for neighbors.Next() {
+				key := edgeKey(n.ID(), neighbors.Node().ID())
+				if key[0] != n.ID() {
+					continue
+				}
+				keys = append(keys, key)
+				subgraph.SetWeightedEdge(subgraph.NewWeightedEdge(simple.Node(key[0]),
+					simple.Node(key[1]), g.graph.WeightedEdge(key[0], key[1]).Weight()))
+			}
+		}
+		// the fixed seed and the node order make the split reproducible
+		communities := community.Modularize(
+			orderedGraph{subgraph}, 1, rand.NewSource(1)).Communities()
+		if len(communities) < 2 {
+			continue
+		}
+		modularity := community.Q(orderedGraph{subgraph}, communities, 1)
+		if modularity < options.MinModularity {
+			continue
+		}
+		groups := map[int64]int{}
+		for i, nodes := range communities {
+			for _, n := range nodes {
+				groups[n.ID()] = i
+			}
+		}
+		// join the communities which are connected by the forced edges
+		parts := newDisjointSet()
+		for _, key := range keys {
+			if forced[key] {
+				parts.union(int64(groups[key[0]]), int64(groups[key[1]]))
+			}
+		}
+		roots := map[int64]struct{}{}
+		for i := range communities {
+			roots[parts.find(int64(i))] = struct{}{}
+		}
+		if len(roots) < 2 {
+			continue
+		}
+		edges := 0
+		for _, key := range keys {
+			if parts.find(int64(groups[key[0]])) != parts.find(int64(groups[key[1]])) {
+				g.graph.RemoveEdge(key[0], key[1])
+				cut[key] = struct{}{}
+				edges++
+			}
+		}
+		logrus.Infof("split the component of %d identities into %d parts, cut %d edges, "+
+			"modularity %.2f", len(component), len(roots), edges, modularity)
+		reporter.Increment("components split")
+		totalParts += len(roots)
+	}
+	reporter.Commit("parts after splitting", totalParts)
+	reporter.Commit("edges cut by splitting", len(cut))
+	if len(cut) == 0 {
+		return
+	}
+	g.removeProvenance(cut)
+	g.updateComponents()
+}
+
+// updateComponents finds the connected components after the edges were removed and sets
+// the external identities found by the external matcher to all the people in the same
+// components, so that the identities propagated over the removed edges are discarded.
+func (g *MatchingGraph) updateComponents() {
+	g.components = newComponentSet(g.graph, g.cannotLink)
+	for _, c := range g.components.components {
+		externalID := ""
+		for _, n := range c.members {
+			if id := g.externalIDs[n.ID()]; id != "" {
+				externalID = id
+			}
+		}
+		for _, n := range c.members {
+			n.Value.ExternalID = externalID
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package idmatch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTeamEmailPeople returns three people with four own emails each. The first identity of each
+// person uses the shared team email.
+func newTeamEmailPeople() People {
+	people := People{}
+	id := int64(1)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		for i := 0; i < 4; i++ {
+			email := fmt.Sprintf("%s%d@corp.com", name, i)
+			if i == 0 {
+				email = "team@corp.com"
+			}
+			people[id] = &Person{ID: id, NamesWithRepos: []NameWithRepo{{name, ""}},
+				Emails: []string{email}}
+			id++
+		}
+	}
+	return people
+}
+
+func TestReducePeopleSplit(t *testing.T) {
+	req := require.New(t)
+	blacklist := newTestBlacklist(t)
+
+	people := newTeamEmailPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{}))
+	req.Len(people, 1)
+
+	people = newTeamEmailPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{
+		Components: ComponentOptions{Split: SplitOptions{MinSize: 10, MinModularity: 0.3}}}))
+	req.Len(people, 3)
+	for _, person := range people {
+		name := person.NamesWithRepos[0].Name
+		req.Equal([]NameWithRepo{{name, ""}}, person.NamesWithRepos)
+		req.Equal([]string{name + "1@corp.com", name + "2@corp.com", name + "3@corp.com",
+			"team@corp.com"}, person.Emails)
+		// the cut edges are not in the provenance
+		req.Len(person.Provenance, 3)
+	}
+
+	// the small components are not split
+	people = newTeamEmailPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{
+		Components: ComponentOptions{Split: SplitOptions{MinSize: 13, MinModularity: 0.3}}}))
+	req.Len(people, 1)
+
+	// the forced edges are never cut
+	people = newTeamEmailPeople()
+	mailmap := Mailmap{{ProperEmail: "alice1@corp.com", CommitEmail: "bob1@corp.com"}}
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{Mailmap: mailmap,
+		Components: ComponentOptions{Split: SplitOptions{MinSize: 10, MinModularity: 0.3}}}))
+	ids := findAliasIDs(people, Alias{Value: "alice1@corp.com"})
+	req.Len(ids, 1)
+	req.Contains(people[ids[0]].Emails, "bob1@corp.com")
+}
+
+func TestReducePeopleDefaultSplit(t *testing.T) {
+	req := require.New(t)
+	blacklist := newTestBlacklist(t)
+	expected := newTeamEmailPeople()
+	req.NoError(ReducePeople(expected, nil, blacklist, 100, ReduceOptions{}))
+	people := newTeamEmailPeople()
+	req.NoError(ReducePeople(people, nil, blacklist, 100, ReduceOptions{
+		Components: ComponentOptions{Split: DefaultSplitOptions}}))
+	req.Equal(expected, people)
+}
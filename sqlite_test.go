@@ -0,0 +1,166 @@
+// +build cgo
+
+package idmatch
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestSQLite creates an SQLite database with the commit metadata, the way a mirror
+// of gitbase could look like.
+func createTestSQLite(t *testing.T, path string) {
+	t.Helper()
+	req := require.New(t)
+	db, err := sql.Open("sqlite3", path)
+	req.NoError(err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE commits (
+		repo TEXT, sha TEXT, author TEXT, mail TEXT, committed_at DATETIME, message TEXT)`)
+	req.NoError(err)
+	for _, row := range [][]interface{}{
+		{"repo1", "aaa", "Bob", "bob@google.com", "2019-10-01 12:00:00", "first"},
+		{"repo1", "bbb", "Bob", "bob@google.com", "2019-10-02 12:00:00",
+			"second\n\nSigned-off-by: Alice <alice@google.com>"},
+		{"repo2", "ccc", "Alice", "alice@google.com", "2019-10-03 12:00:00", "third"},
+	} {
+		_, err = db.Exec("INSERT INTO commits VALUES (?, ?, ?, ?, ?, ?)", row...)
+		req.NoError(err)
+	}
+}
+
+func TestReadSignaturesFromSQLite(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "commits.sqlite")
+	createTestSQLite(t, path)
+	day := func(d int) time.Time { return time.Date(2019, 10, d, 12, 0, 0, 0, time.UTC) }
+
+	source := SignatureSource{
+		Driver:     "sqlite3",
+		ConnString: path,
+		Query: `SELECT repo, author, mail, MAX(sha), MAX(committed_at) FROM commits
+			WHERE committed_at < '2019-10-03' GROUP BY repo, author, mail`,
+	}
+	signatures, err := readSignaturesFromDatabase(context.Background(), source)
+	req.NoError(err)
+	req.Equal([]signatureWithRepo{
+		{repo: "repo1", name: "Bob", email: "bob@google.com", hash: "bbb", time: day(2),
+			role: roleAuthor},
+	}, signatures)
+
+	source.Query = `SELECT mail AS e, author AS n, repo AS r, MAX(committed_at) AS t,
+		MAX(sha) AS h, 'committer' AS k FROM commits GROUP BY repo, author, mail`
+	source.Columns = SignatureColumns{
+		Repo: "r", Name: "n", Email: "e", Hash: "h", Time: "t", Role: "k"}
+	signatures, err = readSignaturesFromDatabase(context.Background(), source)
+	req.NoError(err)
+	req.Equal([]signatureWithRepo{
+		{repo: "repo1", name: "Bob", email: "bob@google.com", hash: "bbb", time: day(2),
+			role: roleCommitter},
+		{repo: "repo2", name: "Alice", email: "alice@google.com", hash: "ccc", time: day(3),
+			role: roleCommitter},
+	}, signatures)
+
+	source.Columns.Hash = "nope"
+	_, err = readSignaturesFromDatabase(context.Background(), source)
+	req.Error(err)
+}
+
+// readSignaturesFromDatabase collects all the signatures streamed from the database.
+func readSignaturesFromDatabase(ctx context.Context, source SignatureSource) (
+	[]signatureWithRepo, error) {
+	var result []signatureWithRepo
+	err := streamSignaturesFromDatabase(ctx, source, collectSignatures(&result))
+	return result, err
+}
+
+// createTestGitbaseSQLite creates an SQLite database with the subset of the gitbase schema
+// which is used by the incremental mode.
+func createTestGitbaseSQLite(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	req := require.New(t)
+	db, err := sql.Open("sqlite3", path)
+	req.NoError(err)
+	for _, query := range []string{
+		`CREATE TABLE commits (repository_id TEXT, commit_hash TEXT,
+			commit_author_name TEXT, commit_author_email TEXT, commit_author_when DATETIME,
+			committer_name TEXT, committer_email TEXT, committer_when DATETIME,
+			commit_message TEXT, commit_parents TEXT)`,
+		`CREATE TABLE refs (repository_id TEXT, ref_name TEXT, commit_hash TEXT)`,
+		`CREATE TABLE ref_commits (repository_id TEXT, commit_hash TEXT, ref_name TEXT)`,
+	} {
+		_, err = db.Exec(query)
+		req.NoError(err)
+	}
+	return db
+}
+
+// addTestGitbaseCommit inserts the commit on top of HEAD and moves HEAD to it.
+func addTestGitbaseCommit(t *testing.T, db *sql.DB, repo, hash, name, when, message string) {
+	t.Helper()
+	req := require.New(t)
+	parents := "[]"
+	var head string
+	err := db.QueryRow("SELECT commit_hash FROM refs WHERE repository_id = ?", repo).Scan(&head)
+	if err != sql.ErrNoRows {
+		req.NoError(err)
+		parents = `["` + head + `"]`
+	}
+	_, err = db.Exec("INSERT INTO commits VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		repo, hash, name, name+"@google.com", when, name, name+"@google.com", when, message,
+		parents)
+	req.NoError(err)
+	_, err = db.Exec("DELETE FROM refs WHERE repository_id = ?", repo)
+	req.NoError(err)
+	_, err = db.Exec("INSERT INTO refs VALUES (?, 'HEAD', ?)", repo, hash)
+	req.NoError(err)
+	_, err = db.Exec("INSERT INTO ref_commits VALUES (?, ?, 'HEAD')", repo, hash)
+	req.NoError(err)
+}
+
+func TestUpdateSignaturesFromSQLite(t *testing.T) {
+	req := require.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "gitbase.sqlite")
+	db := createTestGitbaseSQLite(t, path)
+	defer db.Close()
+	addTestGitbaseCommit(t, db, `it's\repo1`, "aaa", "Bob", "2019-10-01 12:00:00", "first")
+	addTestGitbaseCommit(t, db, "repo2", "bbb", "Alice", "2019-10-02 12:00:00", "second")
+
+	cache := filepath.Join(dir, "cache.csv")
+	source := SignatureSource{
+		Driver: "sqlite3", ConnString: path, Incremental: true, Trailers: true}
+	signatures, err := findSignatures(context.Background(), source, cache)
+	req.NoError(err)
+	req.Len(signatures, 2)
+
+	addTestGitbaseCommit(t, db, `it's\repo1`, "ccc", "Bob", "2019-10-03 12:00:00",
+		"third\n\nReviewed-by: Carol <carol@google.com>")
+	// committed earlier than the rest and pushed later
+	addTestGitbaseCommit(t, db, `it's\repo1`, "ddd", "Dave", "2019-09-30 12:00:00", "fourth")
+	signatures, err = findSignatures(context.Background(), source, cache)
+	req.NoError(err)
+	// the unchanged repository goes first
+	req.Equal([]signatureWithRepo{
+		{repo: "repo2", name: "Alice", email: "alice@google.com", hash: "bbb",
+			time: time.Date(2019, 10, 2, 12, 0, 0, 0, time.UTC), role: roleAuthor},
+		{repo: `it's\repo1`, name: "Bob", email: "bob@google.com", hash: "ccc",
+			time: time.Date(2019, 10, 3, 12, 0, 0, 0, time.UTC), role: roleAuthor},
+		{repo: `it's\repo1`, name: "Dave", email: "dave@google.com", hash: "ddd",
+			time: time.Date(2019, 9, 30, 12, 0, 0, 0, time.UTC), role: roleAuthor},
+		{repo: `it's\repo1`, name: "Carol", email: "carol@google.com", hash: "ccc",
+			time: time.Date(2019, 10, 3, 12, 0, 0, 0, time.UTC), role: "reviewed-by"},
+	}, signatures)
+	states, err := readRepositoryStates(stateCachePath(cache))
+	req.NoError(err)
+	req.Equal([]string{"ddd"}, states[`it's\repo1`].Heads)
+}
@@ -0,0 +1,201 @@
+package idmatch
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/src-d/identity-matching/reporter"
+)
+
+// RetiredID is the ID of a previous person which was not kept by the later runs.
+type RetiredID struct {
+	ID int64
+	// Successor is the ID of the person who got the most aliases of the retired person at
+	// the moment of retirement. Zero means that none of the aliases remained.
+	Successor int64
+}
+
+// parquetRetiredID is RetiredID in Parquet.
+type parquetRetiredID struct {
+	ID        int64 `parquet:"name=id, type=INT_64"`
+	Successor int64 `parquet:"name=successor, type=INT_64"`
+}
+
+// personAliases returns the emails and the names with repositories of the person.
+func personAliases(person *Person) []string {
+	aliases := make([]string, 0, len(person.Emails)+len(person.NamesWithRepos))
+	for _, email := range person.Emails {
+		aliases = append(aliases, "email "+email)
+	}
+	for _, name := range person.NamesWithRepos {
+		aliases = append(aliases, "name "+name.String())
+	}
+	return aliases
+}
+
+// aliasOverlap is the number of shared aliases between the current and the previous person.
+type aliasOverlap struct {
+	current  int64
+	previous int64
+	count    int
+}
+
+// findAliasOverlaps returns the overlaps sorted by the number of shared aliases in
+// the descending order. The ties are broken by the smaller previous and current IDs.
+func findAliasOverlaps(people, previous People) []aliasOverlap {
+	alias2previous := map[string][]int64{}
+	previous.ForEach(func(id int64, person *Person) bool {
+		for _, alias := range personAliases(person) {
+			alias2previous[alias] = append(alias2previous[alias], id)
+		}
+		return false
+	})
+	var overlaps []aliasOverlap
+	people.ForEach(func(id int64, person *Person) bool {
+		counts := map[int64]int{}
+		for _, alias := range personAliases(person) {
+			for _, previousID := range alias2previous[alias] {
+				counts[previousID]++
+			}
+		}
+		for previousID, count := range counts {
+			overlaps = append(overlaps, aliasOverlap{id, previousID, count})
+		}
+		return false
+	})
+	sort.Slice(overlaps, func(i, j int) bool {
+		if overlaps[i].count != overlaps[j].count {
+			return overlaps[i].count > overlaps[j].count
+		}
+		if overlaps[i].previous != overlaps[j].previous {
+			return overlaps[i].previous < overlaps[j].previous
+		}
+		return overlaps[i].current < overlaps[j].current
+	})
+	return overlaps
+}
+
+// AssignStableIDs changes the IDs of the people to the IDs of the previous people with
+// the overlapping aliases, e.g. the people loaded with ReadFromParquet from the output of
+// the previous run. The pairs of the current and the previous people with the most shared
+// aliases are matched first, so that each person keeps at most one previous ID and each previous
+// ID is kept by at most one person. Thus if several previous people were merged, the merged
+// person keeps the ID of the one with the most shared aliases and the other IDs are retired.
+// If a previous person was split, the part with the most shared aliases keeps the ID and
+// the other parts get new IDs. The new IDs are greater than any previous or retired ID.
+//
+// retired are the IDs retired by the previous runs. AssignStableIDs returns them together with
+// the IDs retired by this run.
+func AssignStableIDs(people, previous People, retired []RetiredID) []RetiredID {
+	overlaps := findAliasOverlaps(people, previous)
+	current2previous := map[int64]int64{}
+	kept := map[int64]struct{}{}
+	for _, overlap := range overlaps {
+		if _, exists := current2previous[overlap.current]; exists {
+			continue
+		}
+		if _, exists := kept[overlap.previous]; exists {
+			continue
+		}
+		current2previous[overlap.current] = overlap.previous
+		kept[overlap.previous] = struct{}{}
+	}
+	var maxID int64
+	for id := range previous {
+		if id > maxID {
+			maxID = id
+		}
+	}
+	for _, id := range retired {
+		if id.ID > maxID {
+			maxID = id.ID
+		}
+	}
+	ids := map[int64]int64{}
+	people.ForEach(func(id int64, person *Person) bool {
+		if previousID, exists := current2previous[id]; exists {
+			ids[id] = previousID
+		} else {
+			maxID++
+			ids[id] = maxID
+		}
+		return false
+	})
+	// the first overlap of every previous person is the one with the most shared aliases
+	successors := map[int64]int64{}
+	for _, overlap := range overlaps {
+		if _, exists := successors[overlap.previous]; !exists {
+			successors[overlap.previous] = ids[overlap.current]
+		}
+	}
+	retired = append([]RetiredID{}, retired...)
+	previous.ForEach(func(id int64, person *Person) bool {
+		if _, exists := kept[id]; !exists {
+			retired = append(retired, RetiredID{ID: id, Successor: successors[id]})
+			reporter.Increment("retired IDs")
+		}
+		return false
+	})
+	reassigned := make([]*Person, 0, len(people))
+	for id, person := range people {
+		person.ID = ids[id]
+		reassigned = append(reassigned, person)
+		delete(people, id)
+	}
+	for _, person := range reassigned {
+		people[person.ID] = person
+	}
+	reporter.Commit("people with previous IDs", len(current2previous))
+	reporter.Commit("people with new IDs", len(people)-len(current2previous))
+	return retired
+}
+
+// retiredPath returns the path to the Parquet file with the retired IDs.
+func retiredPath(rawPath string) string {
+	return strings.TrimSuffix(rawPath, ".parquet") + "-retired.parquet"
+}
+
+// WriteRetiredIDs saves the retired IDs next to the People written by WriteToParquet to path.
+func WriteRetiredIDs(path string, retired []RetiredID) error {
+	path = retiredPath(path)
+	// Parquet cannot read the files without row groups
+	if len(retired) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	pw, cleanup := getParquetWriter(path, new(parquetRetiredID))
+	defer cleanup()
+	for _, id := range retired {
+		if err := pw.Write(parquetRetiredID{id.ID, id.Successor}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRetiredIDs loads the retired IDs saved by WriteRetiredIDs next to the People at path.
+// It returns nil if there are none.
+func ReadRetiredIDs(path string) ([]RetiredID, error) {
+	path = retiredPath(path)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	pr, cleanup := getParquetReader(path, new(parquetRetiredID))
+	defer cleanup()
+	rows := make([]parquetRetiredID, int(pr.GetNumRows()))
+	if err := pr.Read(&rows); err != nil {
+		logrus.Printf("read error in %s: %v", path, err)
+		return nil, err
+	}
+	pr.ReadStop()
+	retired := make([]RetiredID, len(rows))
+	for i, row := range rows {
+		retired[i] = RetiredID{ID: row.ID, Successor: row.Successor}
+	}
+	return retired, nil
+}
@@ -0,0 +1,71 @@
+package idmatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssignStableIDs(t *testing.T) {
+	req := require.New(t)
+	previous := People{
+		10: {ID: 10, NamesWithRepos: []NameWithRepo{{"alice", ""}}, Emails: []string{"alice@google.com"}},
+		11: {ID: 11, NamesWithRepos: []NameWithRepo{{"bob", ""}}, Emails: []string{"bob@google.com"}},
+		12: {ID: 12, NamesWithRepos: []NameWithRepo{{"carol", ""}},
+			Emails: []string{"carol@google.com", "carol@gmail.com"}},
+		15: {ID: 15, NamesWithRepos: []NameWithRepo{{"dave", ""}}, Emails: []string{"dave@google.com"}},
+	}
+	people := People{
+		// alice and bob were merged
+		1: {ID: 1, NamesWithRepos: []NameWithRepo{{"alice", ""}, {"bob", "repo"}},
+			Emails: []string{"alice@google.com", "bob@google.com"}},
+		// carol was split
+		2: {ID: 2, NamesWithRepos: []NameWithRepo{{"carol", ""}}, Emails: []string{"carol@google.com"}},
+		3: {ID: 3, NamesWithRepos: []NameWithRepo{{"carol c.", ""}}, Emails: []string{"carol@gmail.com"}},
+		// eve is new
+		4: {ID: 4, NamesWithRepos: []NameWithRepo{{"eve", ""}}, Emails: []string{"eve@google.com"}},
+	}
+	retired := AssignStableIDs(people, previous, []RetiredID{{ID: 20, Successor: 10}})
+	req.Equal([]RetiredID{{ID: 20, Successor: 10}, {ID: 11, Successor: 10}, {ID: 15}}, retired)
+	req.Len(people, 4)
+	for id, person := range people {
+		req.Equal(id, person.ID)
+	}
+	req.Equal("alice@google.com", people[10].Emails[0])
+	req.Equal("carol@google.com", people[12].Emails[0])
+	req.Equal("carol@gmail.com", people[21].Emails[0])
+	req.Equal("eve@google.com", people[22].Emails[0])
+
+	// the second run with the same people keeps all the IDs
+	again := People{}
+	for id, person := range people {
+		copied := *person
+		again[id+100] = &copied
+		again[id+100].ID = id + 100
+	}
+	req.Equal(retired, AssignStableIDs(again, people, retired))
+	req.Equal(people, again)
+}
+
+func TestWriteAndReadRetiredIDs(t *testing.T) {
+	req := require.New(t)
+	dir, err := ioutil.TempDir("", "idmatch")
+	req.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "people.parquet")
+
+	retired := []RetiredID{{ID: 11, Successor: 10}, {ID: 15}}
+	req.NoError(WriteRetiredIDs(path, retired))
+	req.FileExists(filepath.Join(dir, "people-retired.parquet"))
+	loaded, err := ReadRetiredIDs(path)
+	req.NoError(err)
+	req.Equal(retired, loaded)
+
+	req.NoError(WriteRetiredIDs(path, nil))
+	loaded, err = ReadRetiredIDs(path)
+	req.NoError(err)
+	req.Nil(loaded)
+}
@@ -45,3 +45,66 @@ func isMn(r rune) bool {
 func removeDiacritical(s string) (string, int, error) {
 	return transform.String(transform.Chain(norm.NFD, transform.RemoveFunc(isMn), norm.NFC), s)
 }
+
+// jaroWinkler returns the Jaro-Winkler similarity of two strings: 1 means equal strings
+// and 0 means no similarity.
+func jaroWinkler(s1, s2 string) float64 {
+	r1, r2 := []rune(s1), []rune(s2)
+	if len(r1) == 0 && len(r2) == 0 {
+		return 1
+	}
+	if len(r1) == 0 || len(r2) == 0 {
+		return 0
+	}
+	window := len(r1)
+	if len(r2) > window {
+		window = len(r2)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	matched1 := make([]bool, len(r1))
+	matched2 := make([]bool, len(r2))
+	matches := 0
+	for i := range r1 {
+		start, end := i-window, i+window+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(r2) {
+			end = len(r2)
+		}
+		for j := start; j < end; j++ {
+			if !matched2[j] && r1[i] == r2[j] {
+				matched1[i], matched2[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions := 0
+	j := 0
+	for i := range r1 {
+		if !matched1[i] {
+			continue
+		}
+		for !matched2[j] {
+			j++
+		}
+		if r1[i] != r2[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(r1)) + m/float64(len(r2)) + (m-float64(transpositions)/2)/m) / 3
+	prefix := 0
+	for prefix < 4 && prefix < len(r1) && prefix < len(r2) && r1[prefix] == r2[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
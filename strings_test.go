@@ -33,3 +33,18 @@ func TestIsCapitalized(t *testing.T) {
 	require.True(isCapitalized("Capitalized"))
 	require.False(isCapitalized(""))
 }
+
+func TestJaroWinkler(t *testing.T) {
+	req := require.New(t)
+	req.Equal(1.0, jaroWinkler("", ""))
+	req.Equal(1.0, jaroWinkler("john", "john"))
+	req.Equal(0.0, jaroWinkler("john", ""))
+	req.Equal(0.0, jaroWinkler("abc", "xyz"))
+	req.InDelta(0.961, jaroWinkler("martha", "marhta"), 0.001)
+	req.InDelta(0.840, jaroWinkler("dwayne", "duane"), 0.001)
+	req.InDelta(0.813, jaroWinkler("dixon", "dicksonx"), 0.001)
+	req.InDelta(0.933, jaroWinkler("jon", "john"), 0.001)
+	// an odd number of transpositions
+	req.InDelta(0.917, jaroWinkler("abcxyz", "bcaxyz"), 0.001)
+	req.Equal(jaroWinkler("jon", "john"), jaroWinkler("john", "jon"))
+}
@@ -0,0 +1,91 @@
+package idmatch
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trailerRoles are the lowercased commit message trailers which mention identities.
+// The trailer kind becomes the role of the corresponding signature.
+var trailerRoles = map[string]struct{}{
+	"co-authored-by": {},
+	"signed-off-by":  {},
+	"reviewed-by":    {},
+	"acked-by":       {},
+	"tested-by":      {},
+	"reported-by":    {},
+	"suggested-by":   {},
+	"helped-by":      {},
+}
+
+var trailerRe = regexp.MustCompile(`^\s*([A-Za-z][A-Za-z-]*)\s*:\s*(\S.*?)\s*<([^<>\s]+)>\s*$`)
+
+// trailer is an identity mentioned in a commit message, e.g. "Signed-off-by: Bob <bob@x.com>".
+type trailer struct {
+	kind  string
+	name  string
+	email string
+}
+
+// parseTrailers extracts the identities from the known trailers in a commit message.
+func parseTrailers(message string) []trailer {
+	var result []trailer
+	for _, line := range strings.Split(message, "\n") {
+		match := trailerRe.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		kind := strings.ToLower(match[1])
+		if _, known := trailerRoles[kind]; !known {
+			continue
+		}
+		result = append(result, trailer{kind: kind, name: match[2], email: match[3]})
+	}
+	return result
+}
+
+// trailerSignatures converts the trailers in a commit message to signatures tagged with the
+// trailer kind.
+func trailerSignatures(repo, hash string, when time.Time, message string) []signatureWithRepo {
+	var result []signatureWithRepo
+	for _, t := range parseTrailers(message) {
+		result = append(result, signatureWithRepo{
+			repo: repo, name: t.name, email: t.email, hash: hash, time: when, role: t.kind})
+	}
+	return result
+}
+
+// latestSignatures keeps the latest signature for each unique repository, name, email and role,
+// the same way findPeopleSQL groups the commits.
+type latestSignatures map[[4]string]signatureWithRepo
+
+func (ls latestSignatures) add(sig signatureWithRepo) {
+	key := [4]string{sig.repo, sig.name, sig.email, sig.role}
+	if prev, exists := ls[key]; !exists || prev.time.Before(sig.time) ||
+		prev.time.Equal(sig.time) && prev.hash < sig.hash {
+		ls[key] = sig
+	}
+}
+
+// slice returns the signatures ordered by repository, role, name and email.
+func (ls latestSignatures) slice() []signatureWithRepo {
+	result := make([]signatureWithRepo, 0, len(ls))
+	for _, sig := range ls {
+		result = append(result, sig)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].repo != result[j].repo {
+			return result[i].repo < result[j].repo
+		}
+		if result[i].role != result[j].role {
+			return result[i].role < result[j].role
+		}
+		if result[i].name != result[j].name {
+			return result[i].name < result[j].name
+		}
+		return result[i].email < result[j].email
+	})
+	return result
+}
@@ -0,0 +1,56 @@
+package idmatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrailers(t *testing.T) {
+	message := `Fix the bug
+
+Some description which mentions Reviewed-by: nobody.
+Not-a-trailer: Bob <bob@google.com>
+Co-authored-by: Alice Smith <alice@google.com>
+co-authored-by:Bob<bob@google.com>
+Signed-off-by: Bob <bob@google.com>
+Reviewed-By: Máximo Cuadros <mcuadros@gmail.com>  
+Acked-by: <anonymous@google.com>
+Tested-by: Broken <broken>>
+`
+	require.Equal(t, []trailer{
+		{"co-authored-by", "Alice Smith", "alice@google.com"},
+		{"co-authored-by", "Bob", "bob@google.com"},
+		{"signed-off-by", "Bob", "bob@google.com"},
+		{"reviewed-by", "Máximo Cuadros", "mcuadros@gmail.com"},
+	}, parseTrailers(message))
+	require.Nil(t, parseTrailers("no trailers"))
+}
+
+func TestTrailerSignatures(t *testing.T) {
+	when := time.Now()
+	require.Equal(t, []signatureWithRepo{
+		{repo: "repo", name: "Alice", email: "alice@google.com", hash: "aaa", time: when,
+			role: "co-authored-by"},
+	}, trailerSignatures("repo", "aaa", when, "Co-authored-by: Alice <alice@google.com>"))
+}
+
+func TestLatestSignatures(t *testing.T) {
+	now := time.Now()
+	latest := latestSignatures{}
+	latest.add(signatureWithRepo{repo: "repo", name: "bob", email: "bob@google.com",
+		hash: "aaa", time: now.Add(-time.Hour), role: roleAuthor})
+	latest.add(signatureWithRepo{repo: "repo", name: "bob", email: "bob@google.com",
+		hash: "bbb", time: now, role: roleAuthor})
+	latest.add(signatureWithRepo{repo: "repo", name: "bob", email: "bob@google.com",
+		hash: "ccc", time: now.Add(-2 * time.Hour), role: roleAuthor})
+	latest.add(signatureWithRepo{repo: "repo", name: "bob", email: "bob@google.com",
+		hash: "ddd", time: now.Add(-2 * time.Hour), role: "signed-off-by"})
+	require.Equal(t, []signatureWithRepo{
+		{repo: "repo", name: "bob", email: "bob@google.com", hash: "bbb", time: now,
+			role: roleAuthor},
+		{repo: "repo", name: "bob", email: "bob@google.com", hash: "ddd",
+			time: now.Add(-2 * time.Hour), role: "signed-off-by"},
+	}, latest.slice())
+}